@@ -0,0 +1,122 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// Gaps returns minimal list of prefixes of within not covered by the set, in ascending order.
+// Ipv6 within never includes ipv4 space, as in ComplementWithin. Nil or invalid within is an error.
+func Gaps(t Tree, within *net.IPNet) ([]*net.IPNet, error) {
+	universe, err := leafFromNet(within)
+	if err != nil {
+		return nil, fmt.Errorf("Gaps: %w", err)
+	}
+
+	s := treeOf(t)
+
+	var gaps []*net.IPNet
+	for _, r := range universeRanges(universe) {
+		s.gaps(r[0], r[1], func(start, end uint128.Uint128) bool {
+			rangeToPrefixes(start, end, func(addr uint128.Uint128, prefix uint32) {
+				gaps = append(gaps, netFromAddr(addr, prefix))
+			})
+			return true
+		})
+	}
+
+	return gaps, nil
+}
+
+// Allocate finds the first free block of prefixLen within, adds it to the set and returns it.
+// Prefix length is counted in the family of within.
+func Allocate(t Tree, within *net.IPNet, prefixLen int) (*net.IPNet, error) {
+	s := treeOf(t)
+	universe, size, err := allocationRequest(within, prefixLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var block *treeNode
+	for _, r := range universeRanges(universe) {
+		s.gaps(r[0], r[1], func(start, end uint128.Uint128) bool {
+			// first address aligned to block size
+			addr := maskAddr(start, size)
+			if !addr.Equals(start) {
+				last := addr.Or(hostMask(size))
+				if last.Equals(uint128.Max) {
+					return false
+				}
+				addr = last.Add64(1)
+			}
+
+			if addr.Cmp(start) >= 0 && addr.Or(hostMask(size)).Cmp(end) <= 0 {
+				block = &treeNode{addr: addr, prefix: size}
+				return false
+			}
+			return true
+		})
+		if block != nil {
+			break
+		}
+	}
+
+	return allocated(t, block, within, prefixLen)
+}
+
+// AllocateBestFit finds the smallest free aligned block able to hold block of prefixLen within,
+// adds block of prefixLen at its start to the set and returns it. It keeps large blocks free
+// at the cost of walking all the gaps.
+func AllocateBestFit(t Tree, within *net.IPNet, prefixLen int) (*net.IPNet, error) {
+	s := treeOf(t)
+	universe, size, err := allocationRequest(within, prefixLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var block *treeNode
+	for _, r := range universeRanges(universe) {
+		s.gaps(r[0], r[1], func(start, end uint128.Uint128) bool {
+			rangeToPrefixes(start, end, func(addr uint128.Uint128, prefix uint32) {
+				if prefix <= size && (block == nil || prefix > block.prefix) {
+					block = &treeNode{addr: addr, prefix: prefix}
+				}
+			})
+			// exact fit can't be beaten
+			return block == nil || block.prefix != size
+		})
+	}
+	if block != nil {
+		block.prefix = size
+	}
+
+	return allocated(t, block, within, prefixLen)
+}
+
+// allocationRequest validates request, returns universe and block prefix in tree keys
+func allocationRequest(within *net.IPNet, prefixLen int) (treeNode, uint32, error) {
+	universe, err := leafFromNet(within)
+	if err != nil {
+		return treeNode{}, 0, fmt.Errorf("Allocate: %w", err)
+	}
+
+	ones, bits := within.Mask.Size()
+	if prefixLen < ones || prefixLen > bits {
+		return treeNode{}, 0, fmt.Errorf("Allocate: prefix length %d out of %s", prefixLen, within)
+	}
+
+	return universe, universe.prefix + uint32(prefixLen-ones), nil
+}
+
+// allocated adds found block to t
+func allocated(t Tree, block *treeNode, within *net.IPNet, prefixLen int) (*net.IPNet, error) {
+	if block == nil {
+		return nil, fmt.Errorf("Allocate: no free block of length %d in %s", prefixLen, within)
+	}
+
+	cidr := netFromAddr(block.addr, block.prefix)
+	t.Add(cidr)
+	return cidr, nil
+}
@@ -0,0 +1,134 @@
+package ipset
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestGaps(t *testing.T) {
+	set := NewTree(parseCidrs("10.0.0.0/24", "10.0.2.0/25", "10.0.4.0/22", "2001:db8::/33")...)
+	testCases := []struct {
+		within   string
+		expected string
+	}{
+		{within: "10.0.0.0/21", expected: "10.0.1.0/24 10.0.2.128/25 10.0.3.0/24"},
+		{within: "10.0.4.0/23", expected: ""},
+		{within: "192.168.0.0/16", expected: "192.168.0.0/16"},
+		{within: "2001:db8::/32", expected: "2001:db8:8000::/33"},
+		{within: "::/95", expected: "::/95"},
+		{within: "::fffe:0:0/95", expected: "::fffe:0:0/96"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.within, func(t *testing.T) {
+			gaps, err := Gaps(set, parseCidrs(tc.within)[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, gap := range gaps {
+				got = append(got, gap.String())
+			}
+			if strings.Join(got, " ") != tc.expected {
+				t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, strings.Join(got, " "))
+			}
+		})
+	}
+
+	for _, within := range []*net.IPNet{nil, {}} {
+		if _, err := Gaps(set, within); err == nil {
+			t.Errorf("gaps of invalid prefix %v were returned", within)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	lab := parseCidrs("10.0.0.0/22")[0]
+	set := NewTree(parseCidrs("10.0.0.0/26", "10.0.0.128/25", "10.0.1.0/24")...)
+
+	for _, expected := range []string{"10.0.0.64/27", "10.0.2.0/23", "10.0.0.96/28"} {
+		ones, _ := parseCidrs(expected)[0].Mask.Size()
+		got, err := Allocate(set, lab, ones)
+		if err != nil {
+			t.Fatalf("Allocate /%d failed: %v", ones, err)
+		}
+		if got.String() != expected {
+			t.Errorf("mismatch (expected: %s, got: %s)", expected, got)
+		}
+		if !set.Contains(got.IP) {
+			t.Errorf("%s was not added", got)
+		}
+	}
+
+	if got, err := Allocate(set, lab, 27); err == nil {
+		t.Errorf("allocated %s from full block", got)
+	}
+	for _, prefixLen := range []int{21, 33} {
+		if _, err := Allocate(set, lab, prefixLen); err == nil {
+			t.Errorf("invalid prefix length %d was accepted", prefixLen)
+		}
+	}
+
+	got, err := Allocate(NewTree(), parseCidrs("::/0")[0], 96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "::/96" {
+		t.Errorf("unexpected ipv6 allocation %s", got)
+	}
+}
+
+func TestAllocateBestFit(t *testing.T) {
+	lab := parseCidrs("10.0.0.0/22")[0]
+	// free: 10.0.0.0/24, 10.0.1.64/26, 10.0.2.0/23
+	set := NewTree(parseCidrs("10.0.1.0/26", "10.0.1.128/25")...)
+
+	for _, expected := range []string{"10.0.1.64/27", "10.0.1.96/27", "10.0.0.0/27", "10.0.2.0/23"} {
+		ones, _ := parseCidrs(expected)[0].Mask.Size()
+		got, err := AllocateBestFit(set, lab, ones)
+		if err != nil {
+			t.Fatalf("AllocateBestFit /%d failed: %v", ones, err)
+		}
+		if got.String() != expected {
+			t.Errorf("mismatch (expected: %s, got: %s)", expected, got)
+		}
+	}
+}
+
+func TestAllocateRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	within := parseCidrs("10.0.0.0/16")[0]
+	set := NewTree()
+	for i := 0; i < 2000; i++ {
+		allocate := Allocate
+		if i%2 == 1 {
+			allocate = AllocateBestFit
+		}
+
+		before := NewTree(set.Prefixes()...)
+		prefixLen := 20 + rng.Intn(13)
+		free, err := Gaps(before, within)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := allocate(set, within, prefixLen)
+		if err != nil {
+			if len(free) != 0 && prefixLen == 32 {
+				t.Fatalf("no /32 allocated with free space left: %v", err)
+			}
+			continue
+		}
+
+		if ones, _ := block.Mask.Size(); ones != prefixLen || !within.Contains(block.IP) {
+			t.Fatalf("unexpected block %s for /%d", block, prefixLen)
+		}
+		if gaps, err := Gaps(before, block); err != nil || len(gaps) != 1 || gaps[0].String() != block.String() {
+			t.Fatalf("%s overlaps used space", block)
+		}
+		if !set.Contains(block.IP) {
+			t.Fatalf("%s was not added", block)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package bpf renders sets as contents of BPF_MAP_TYPE_LPM_TRIE maps and as pcap filter expressions.
+package bpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kentik/ipset"
+)
+
+// Key sizes of ipv4 and ipv6 LPM trie maps, prefix length followed by address
+const (
+	IPv4KeySize = 4 + net.IPv4len
+	IPv6KeySize = 4 + net.IPv6len
+)
+
+// split returns minimal lists of ipv4 and ipv6 prefixes of s, see ipset.PrefixesByFamily
+func split(s ipset.Set) (ipv4, ipv6 []*net.IPNet, err error) {
+	ipv4, ipv6, err = ipset.PrefixesByFamily(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bpf: %w", err)
+	}
+
+	return ipv4, ipv6, nil
+}
+
+// IPv4LPMKeys returns keys of ipv4 LPM trie map matching s. Key is struct bpf_lpm_trie_key, prefix length
+// is u32 in byte order of the host loading the map, usually binary.LittleEndian, address is in network order.
+func IPv4LPMKeys(s ipset.Set, order binary.ByteOrder) ([][]byte, error) {
+	ipv4, _, err := split(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return lpmKeys(ipv4, order), nil
+}
+
+// IPv6LPMKeys returns keys of ipv6 LPM trie map matching s, see IPv4LPMKeys
+func IPv6LPMKeys(s ipset.Set, order binary.ByteOrder) ([][]byte, error) {
+	_, ipv6, err := split(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return lpmKeys(ipv6, order), nil
+}
+
+func lpmKeys(prefixes []*net.IPNet, order binary.ByteOrder) [][]byte {
+	keys := make([][]byte, len(prefixes))
+	for i, p := range prefixes {
+		ones, _ := p.Mask.Size()
+		key := make([]byte, 4, 4+len(p.IP))
+		order.PutUint32(key, uint32(ones))
+		keys[i] = append(key, p.IP...)
+	}
+
+	return keys
+}
+
+// Directions of filter expression
+const (
+	Any = ""
+	Src = "src"
+	Dst = "dst"
+)
+
+// FilterExpression returns pcap filter expression matching packets of addresses in s, like
+// "net 10.0.0.0/24 or host 192.168.1.1", direction is Any, Src or Dst. Empty set has no
+// expression matching no packets, an error is returned for it.
+func FilterExpression(s ipset.Set, direction string) (string, error) {
+	if direction != Any && direction != Src && direction != Dst {
+		return "", fmt.Errorf("bpf: invalid direction %q", direction)
+	}
+
+	ipv4, ipv6, err := split(s)
+	if err != nil {
+		return "", err
+	}
+	if len(ipv4)+len(ipv6) == 0 {
+		return "", errors.New("bpf: empty set")
+	}
+
+	qualifier := ""
+	if direction != Any {
+		qualifier = direction + " "
+	}
+
+	terms := make([]string, 0, len(ipv4)+len(ipv6))
+	for _, p := range append(ipv4, ipv6...) {
+		if ones, bits := p.Mask.Size(); ones == bits {
+			terms = append(terms, qualifier+"host "+p.IP.String())
+		} else {
+			terms = append(terms, qualifier+"net "+p.String())
+		}
+	}
+
+	return strings.Join(terms, " or "), nil
+}
@@ -0,0 +1,91 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/kentik/ipset"
+)
+
+func parseCidrs(s ...string) []*net.IPNet {
+	res := make([]*net.IPNet, len(s))
+	for i, ss := range s {
+		_, cidr, err := net.ParseCIDR(ss)
+		if err != nil {
+			panic(err)
+		}
+		res[i] = cidr
+	}
+
+	return res
+}
+
+func TestLPMKeys(t *testing.T) {
+	set := ipset.NewSet(parseCidrs("10.0.0.0/25", "10.0.0.128/25", "192.168.1.1/32", "2001:db8::/32")...)
+
+	keys, err := IPv4LPMKeys(set, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{
+		{24, 0, 0, 0, 10, 0, 0, 0},
+		{32, 0, 0, 0, 192, 168, 1, 1},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("mismatch (expected: %v, got: %v)", expected, keys)
+	}
+
+	keys, err = IPv6LPMKeys(set, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = [][]byte{
+		{0, 0, 0, 32, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	if !reflect.DeepEqual(keys, expected) || len(keys[0]) != IPv6KeySize {
+		t.Errorf("mismatch (expected: %v, got: %v)", expected, keys)
+	}
+
+	keys, err = IPv4LPMKeys(ipset.NewSet(parseCidrs("::/64")...), binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := [][]byte{{0, 0, 0, 0, 0, 0, 0, 0}}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("ipv6 prefix enclosing ipv4 space (expected: %v, got: %v)", expected, keys)
+	}
+}
+
+func TestFilterExpression(t *testing.T) {
+	set := ipset.NewSet(parseCidrs("10.0.0.0/25", "10.0.0.128/25", "192.168.1.1/32", "2001:db8::/32", "2001:db9::1/128")...)
+	testCases := []struct {
+		direction string
+		expected  string
+	}{
+		{
+			direction: Any,
+			expected:  "net 10.0.0.0/24 or host 192.168.1.1 or net 2001:db8::/32 or host 2001:db9::1",
+		},
+		{
+			direction: Src,
+			expected:  "src net 10.0.0.0/24 or src host 192.168.1.1 or src net 2001:db8::/32 or src host 2001:db9::1",
+		},
+	}
+	for _, tc := range testCases {
+		expr, err := FilterExpression(set, tc.direction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expr != tc.expected {
+			t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, expr)
+		}
+	}
+
+	if _, err := FilterExpression(ipset.NewSet(), Any); err == nil {
+		t.Errorf("expression of empty set was returned")
+	}
+	if _, err := FilterExpression(set, "both"); err == nil {
+		t.Errorf("invalid direction was accepted")
+	}
+}
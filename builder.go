@@ -0,0 +1,178 @@
+package ipset
+
+import (
+	"net"
+	"sort"
+
+	"lukechampine.com/uint128"
+)
+
+// Builder constructs Tree from large number of prefixes, instead of walking the tree from the root for every prefix
+// it sorts them once and builds the tree bottom-up, the result is the same as the one of NewTree.
+// Zero value is ready to use, sorting is skipped when prefixes are added in ascending order.
+type Builder struct {
+	leaves   []treeNode
+	unsorted bool
+}
+
+// Add queues cidr for insertion
+func (b *Builder) Add(cidr *net.IPNet) {
+	node, err := leafFromNet(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	b.add(node)
+}
+
+func (b *Builder) add(node treeNode) {
+	if n := len(b.leaves); n > 0 && nodeLess(&node, &b.leaves[n-1]) {
+		b.unsorted = true
+	}
+	b.leaves = append(b.leaves, node)
+}
+
+// Build constructs Tree from queued prefixes and resets the builder
+func (b *Builder) Build() Tree {
+	leaves := b.leaves
+	if b.unsorted {
+		sortNodes(leaves, 0)
+	}
+	b.leaves, b.unsorted = nil, false
+
+	leaves = aggregate(leaves)
+	s := &ipset{}
+	if len(leaves) > 0 {
+		s.nodes = make([]treeNode, 1, 2*len(leaves))
+		s.root = s.build(leaves, 0)
+	}
+
+	return s
+}
+
+// nodeLess orders nodes by address, enclosing prefixes go first
+func nodeLess(l, r *treeNode) bool {
+	if c := l.addr.Cmp(r.addr); c != 0 {
+		return c < 0
+	}
+
+	return l.prefix < r.prefix
+}
+
+type nodesByAddr []treeNode
+
+func (n nodesByAddr) Len() int           { return len(n) }
+func (n nodesByAddr) Less(i, j int) bool { return nodeLess(&n[i], &n[j]) }
+func (n nodesByAddr) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+
+// sortNodes orders nodes by address with in place msd radix sort on byte of address at index and following ones
+func sortNodes(nodes []treeNode, index uint) {
+	if index == 16 {
+		sort.Sort(nodesByAddr(nodes))
+		return
+	}
+
+	if len(nodes) < 64 {
+		for i := 1; i < len(nodes); i++ {
+			for j := i; j > 0 && nodeLess(&nodes[j], &nodes[j-1]); j-- {
+				nodes[j], nodes[j-1] = nodes[j-1], nodes[j]
+			}
+		}
+		return
+	}
+
+	var counts [256]int
+	for i := range nodes {
+		counts[addrByte(nodes[i].addr, index)]++
+	}
+
+	var starts, ends [256]int
+	sum := 0
+	for b, count := range counts {
+		if count == len(nodes) {
+			// all nodes share the byte, nothing to move
+			sortNodes(nodes, index+1)
+			return
+		}
+
+		starts[b] = sum
+		sum += count
+		ends[b] = sum
+	}
+
+	next := starts
+	for b := range next {
+		for next[b] < ends[b] {
+			dst := addrByte(nodes[next[b]].addr, index)
+			if int(dst) == b {
+				next[b]++
+				continue
+			}
+
+			nodes[next[b]], nodes[next[dst]] = nodes[next[dst]], nodes[next[b]]
+			next[dst]++
+		}
+	}
+
+	for b, count := range counts {
+		if count > 1 {
+			sortNodes(nodes[starts[b]:ends[b]], index+1)
+		}
+	}
+}
+
+// addrByte returns byte of address at index, counting from the most significant one
+func addrByte(addr uint128.Uint128, index uint) byte {
+	if index < 8 {
+		return byte(addr.Hi >> (56 - 8*index))
+	}
+
+	return byte(addr.Lo >> (56 - 8*(index-8)))
+}
+
+// aggregate drops covered prefixes from sorted list and merges complementing ones, list is modified in place
+func aggregate(sorted []treeNode) []treeNode {
+	out := sorted[:0]
+	for _, node := range sorted {
+		if n := len(out); n > 0 {
+			top := &out[n-1]
+			if top.prefix <= node.prefix && matchingPrefix(top.addr, node.addr) >= top.prefix {
+				continue
+			}
+		}
+
+		out = append(out, node)
+		for n := len(out); n > 1; n = len(out) {
+			l, r := &out[n-2], &out[n-1]
+			if l.prefix != r.prefix || l.prefix == 0 || matchingPrefix(l.addr, r.addr) != l.prefix-1 {
+				break
+			}
+
+			l.prefix--
+			out = out[:n-1]
+		}
+	}
+
+	return out
+}
+
+// build stores sorted disjoint leaves as a subtree hanging at offset, returns index of subtree root
+func (s *ipset) build(leaves []treeNode, offset uint32) uint32 {
+	if len(leaves) == 1 {
+		return s.alloc(treeNode{addr: leaves[0].addr, prefix: leaves[0].prefix - offset})
+	}
+
+	first, last := leaves[0].addr, leaves[len(leaves)-1].addr
+	matching := matchingPrefix(first, last)
+	split := sort.Search(len(leaves), func(i int) bool {
+		return leaves[i].addr.Rsh(uint(128-(matching+1))).And64(0x01) != uint128.Zero
+	})
+
+	left := s.build(leaves[:split], matching)
+	right := s.build(leaves[split:], matching)
+
+	i := s.alloc(treeNode{addr: first, prefix: matching - offset, left: left, right: right})
+	s.updateCount(i, offset)
+
+	return i
+}
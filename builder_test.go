@@ -0,0 +1,192 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	testCases := []struct {
+		desc  string
+		cidrs []*net.IPNet
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc:  "covered prefixes",
+			cidrs: parseCidrs("10.1.0.0/16", "10.0.0.0/8", "10.2.3.0/24", "10.0.0.0/8"),
+		},
+		{
+			desc:  "complementing prefixes",
+			cidrs: parseCidrs("10.0.0.0/25", "10.0.0.128/26", "10.0.0.192/26", "10.0.1.0/24", "10.0.3.0/24"),
+		},
+		{
+			desc:  "halves of the key space",
+			cidrs: parseCidrs("::/1", "8000::/1"),
+		},
+		{
+			desc:  "random ipv4",
+			cidrs: randomIPv4Cidrs(rng, 5000),
+		},
+		{
+			desc:  "random mixed",
+			cidrs: append(randomIPv4Cidrs(rng, 5000), randomIPv6Cidrs(rng, 5000)...),
+		},
+		{
+			desc:  "dense ipv4",
+			cidrs: denseIPv4Cidrs(rng, 5000),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			expected := NewTree(tc.cidrs...).(*ipset)
+
+			var b Builder
+			for _, cidr := range tc.cidrs {
+				b.Add(cidr)
+			}
+			if err := sameTree(expected, b.Build().(*ipset)); err != nil {
+				t.Errorf("unsorted input: %v", err)
+			}
+
+			sorted := append([]*net.IPNet(nil), tc.cidrs...)
+			sort.Slice(sorted, func(i, j int) bool {
+				l, _ := nodeFromNet(sorted[i])
+				r, _ := nodeFromNet(sorted[j])
+				return nodeLess(l, r)
+			})
+			for _, cidr := range sorted {
+				b.Add(cidr)
+			}
+			if b.unsorted {
+				t.Errorf("sorted input was detected as unsorted")
+			}
+			if err := sameTree(expected, b.Build().(*ipset)); err != nil {
+				t.Errorf("sorted input: %v", err)
+			}
+		})
+	}
+}
+
+// denseIPv4Cidrs returns random prefixes from small space so that many of them complement each other
+func denseIPv4Cidrs(rng *rand.Rand, n int) []*net.IPNet {
+	cidrs := make([]*net.IPNet, n)
+	for i := range cidrs {
+		prefixLen := 20 + rng.Intn(13)
+		mask := net.CIDRMask(prefixLen, 32)
+		ip := net.IPv4(10, 0, byte(rng.Intn(16)), byte(rng.Intn(256))).To4()
+		cidrs[i] = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+
+	return cidrs
+}
+
+// sameTree tells whether both trees have the same shape and hold the same prefixes
+func sameTree(l, r *ipset) error {
+	var compare func(li, ri, offset uint32) error
+	compare = func(li, ri, offset uint32) error {
+		ln, rn := &l.nodes[li], &r.nodes[ri]
+		if ln.prefix != rn.prefix {
+			return fmt.Errorf("prefix mismatch at %d (%d vs %d)", offset, ln.prefix, rn.prefix)
+		}
+		offset += ln.prefix
+		if !maskAddr(ln.addr, offset).Equals(maskAddr(rn.addr, offset)) {
+			return fmt.Errorf("addr mismatch at %d (%s vs %s)", offset, ln, rn)
+		}
+		if (ln.left == 0) != (rn.left == 0) {
+			return fmt.Errorf("leaf mismatch at %d (%s vs %s)", offset, ln, rn)
+		}
+		if ln.left == 0 {
+			return nil
+		}
+		if l.counts != nil && r.counts != nil && l.counts[li] != r.counts[ri] {
+			return fmt.Errorf("count mismatch at %d (%+v vs %+v)", offset, l.counts[li], r.counts[ri])
+		}
+		if err := compare(ln.left, rn.left, offset); err != nil {
+			return err
+		}
+		return compare(ln.right, rn.right, offset)
+	}
+
+	if (l.root == 0) != (r.root == 0) {
+		return fmt.Errorf("only one of trees is empty")
+	}
+	if l.root == 0 {
+		return nil
+	}
+
+	return compare(l.root, r.root, 0)
+}
+
+// ribLikeCidrs returns prefixes resembling full bgp table, mostly disjoint ipv4 /16-/24 and ipv6 /32-/48
+func ribLikeCidrs(rng *rand.Rand, n int) []*net.IPNet {
+	cidrs := make([]*net.IPNet, n)
+	for i := range cidrs {
+		var ip net.IP
+		var mask net.IPMask
+		if i%4 != 0 {
+			ip = make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, rng.Uint32())
+			mask = net.CIDRMask(24-rng.Intn(9)*rng.Intn(2), 32)
+		} else {
+			ip = make(net.IP, 16)
+			binary.BigEndian.PutUint64(ip, 0x2000000000000000|rng.Uint64()>>3)
+			mask = net.CIDRMask(48-rng.Intn(17)*rng.Intn(2), 128)
+		}
+		cidrs[i] = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+
+	return cidrs
+}
+
+func BenchmarkNewTreeRIB(b *testing.B) {
+	cidrs := ribLikeCidrs(rand.New(rand.NewSource(1)), 1000000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewTree(cidrs...)
+	}
+}
+
+func BenchmarkBuilder(b *testing.B) {
+	cidrs := ribLikeCidrs(rand.New(rand.NewSource(1)), 1000000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var builder Builder
+		for _, cidr := range cidrs {
+			builder.Add(cidr)
+		}
+		builder.Build()
+	}
+}
+
+func BenchmarkBuilderSorted(b *testing.B) {
+	var builder Builder
+	for _, cidr := range ribLikeCidrs(rand.New(rand.NewSource(1)), 1000000) {
+		builder.Add(cidr)
+	}
+	sortNodes(builder.leaves, 0)
+	cidrs := make([]*net.IPNet, len(builder.leaves))
+	for i, leaf := range builder.leaves {
+		cidrs[i] = netFromAddr(leaf.addr, leaf.prefix)
+	}
+	builder.leaves = nil
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, cidr := range cidrs {
+			builder.Add(cidr)
+		}
+		builder.Build()
+	}
+}
@@ -0,0 +1,208 @@
+// Package cloud loads ip ranges published by cloud providers into prefix tables and sets.
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/kentik/ipset"
+)
+
+// Providers of the ranges
+const (
+	AWS   = "aws"
+	GCP   = "gcp"
+	Azure = "azure"
+)
+
+// Range is a prefix announced by the provider for given region and service
+type Range struct {
+	Prefix   *net.IPNet
+	Provider string
+	Region   string
+	Service  string
+}
+
+// ParseAWS parses ip-ranges.json document
+func ParseAWS(r io.Reader) ([]Range, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("aws: %w", err)
+	}
+
+	ranges := make([]Range, 0, len(doc.Prefixes)+len(doc.IPv6Prefixes))
+	for _, p := range doc.Prefixes {
+		rng, err := newRange(AWS, p.IPPrefix, p.Region, p.Service)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rng)
+	}
+	for _, p := range doc.IPv6Prefixes {
+		rng, err := newRange(AWS, p.IPv6Prefix, p.Region, p.Service)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rng)
+	}
+
+	return ranges, nil
+}
+
+// ParseGCP parses cloud.json document, scope of the prefix is used as its region
+func ParseGCP(r io.Reader) ([]Range, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("gcp: %w", err)
+	}
+
+	ranges := make([]Range, 0, len(doc.Prefixes))
+	for _, p := range doc.Prefixes {
+		prefix := p.IPv4Prefix
+		if prefix == "" {
+			prefix = p.IPv6Prefix
+		}
+
+		rng, err := newRange(GCP, prefix, p.Scope, p.Service)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rng)
+	}
+
+	return ranges, nil
+}
+
+// ParseAzure parses Service Tags document, tags without system service like AzureCloud.eastus
+// use the part of their name before the dot as the service
+func ParseAzure(r io.Reader) ([]Range, error) {
+	var doc struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("azure: %w", err)
+	}
+
+	var ranges []Range
+	for _, v := range doc.Values {
+		service := v.Properties.SystemService
+		if service == "" {
+			service = v.Name
+			if i := strings.IndexByte(service, '.'); i >= 0 {
+				service = service[:i]
+			}
+		}
+
+		for _, prefix := range v.Properties.AddressPrefixes {
+			rng, err := newRange(Azure, prefix, v.Properties.Region, service)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, rng)
+		}
+	}
+
+	return ranges, nil
+}
+
+func newRange(provider, prefix, region, service string) (Range, error) {
+	_, cidr, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return Range{}, fmt.Errorf("%s: %w", provider, err)
+	}
+
+	return Range{Prefix: cidr, Provider: provider, Region: region, Service: service}, nil
+}
+
+// Table maps prefixes to ranges published for them
+type Table struct {
+	m *ipset.Map
+}
+
+// NewTable constructs Table from ranges of one or more providers
+func NewTable(ranges ...[]Range) *Table {
+	t := &Table{m: ipset.NewMap()}
+	for _, rs := range ranges {
+		for _, r := range rs {
+			var stored []Range
+			if v, ok := t.m.Get(r.Prefix); ok {
+				stored = v.([]Range)
+			}
+			t.m.Insert(r.Prefix, append(stored, r))
+		}
+	}
+
+	return t
+}
+
+// Lookup returns ranges of the longest prefix containing ip, the same prefix is often listed
+// for several services, like AMAZON and EC2. Returned slice is a copy, changing it doesn't affect the table.
+func (t *Table) Lookup(ip net.IP) ([]Range, bool) {
+	v, _, ok := t.m.Lookup(ip)
+	if !ok {
+		return nil, false
+	}
+
+	return append([]Range(nil), v.([]Range)...), true
+}
+
+// Len returns number of distinct prefixes in the table
+func (t *Table) Len() int {
+	return t.m.Len()
+}
+
+// Filter selects ranges, empty fields match any value, comparison ignores case
+type Filter struct {
+	Provider string
+	Region   string
+	Service  string
+}
+
+// Match tells whether r passes the filter
+func (f Filter) Match(r Range) bool {
+	return matchField(f.Provider, r.Provider) && matchField(f.Region, r.Region) && matchField(f.Service, r.Service)
+}
+
+func matchField(filter, value string) bool {
+	return filter == "" || strings.EqualFold(filter, value)
+}
+
+// NewSet constructs Set of prefixes of ranges passing the filter
+func NewSet(ranges []Range, f Filter) ipset.Set {
+	var b ipset.Builder
+	for _, r := range ranges {
+		if f.Match(r) {
+			b.Add(r.Prefix)
+		}
+	}
+
+	return b.Build()
+}
@@ -0,0 +1,137 @@
+package cloud
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadRanges(t *testing.T, path string, parse func(io.Reader) ([]Range, error)) []Range {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ranges, err := parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ranges
+}
+
+func allRanges(t *testing.T) (aws, gcp, azure []Range) {
+	aws = loadRanges(t, "testdata/aws-ip-ranges.json", ParseAWS)
+	gcp = loadRanges(t, "testdata/gcp-cloud.json", ParseGCP)
+	azure = loadRanges(t, "testdata/azure-service-tags.json", ParseAzure)
+
+	return aws, gcp, azure
+}
+
+func TestParse(t *testing.T) {
+	aws, gcp, azure := allRanges(t)
+	if len(aws) != 7 || len(gcp) != 4 || len(azure) != 6 {
+		t.Fatalf("unexpected range counts (aws: %d, gcp: %d, azure: %d)", len(aws), len(gcp), len(azure))
+	}
+
+	testCases := []struct {
+		rng      Range
+		expected string
+	}{
+		{rng: aws[0], expected: "3.2.34.0/26 aws af-south-1 AMAZON"},
+		{rng: aws[6], expected: "2600:1f14:4000::/36 aws us-west-2 EC2"},
+		{rng: gcp[3], expected: "2600:1900:4000::/44 gcp us-central1 Google Cloud"},
+		{rng: azure[0], expected: "13.66.60.119/32 azure  ActionGroup"},
+		{rng: azure[3], expected: "20.42.0.0/17 azure eastus AzureCloud"},
+		{rng: azure[5], expected: "20.42.0.0/24 azure eastus AzureStorage"},
+	}
+	for _, tc := range testCases {
+		r := tc.rng
+		if got := strings.Join([]string{r.Prefix.String(), r.Provider, r.Region, r.Service}, " "); got != tc.expected {
+			t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, got)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := ParseAWS(strings.NewReader(`{"prefixes": [{"ip_prefix": "3.2.34.0/33"}]}`)); err == nil {
+		t.Errorf("invalid prefix was accepted")
+	}
+	if _, err := ParseGCP(strings.NewReader(`{"prefixes": [{}]}`)); err == nil {
+		t.Errorf("missing prefix was accepted")
+	}
+	if _, err := ParseAzure(strings.NewReader(`{"values": `)); err == nil {
+		t.Errorf("truncated document was accepted")
+	}
+}
+
+func TestTableLookup(t *testing.T) {
+	table := NewTable(allRanges(t))
+	if table.Len() != 16 {
+		t.Errorf("unexpected table length %d", table.Len())
+	}
+
+	testCases := []struct {
+		ip       string
+		expected []string
+	}{
+		{ip: "3.5.141.1", expected: []string{"AMAZON", "S3"}},
+		{ip: "52.94.76.10", expected: []string{"EC2"}},
+		{ip: "52.94.77.10", expected: []string{"AMAZON"}},
+		{ip: "2600:1f14:4000::1", expected: []string{"EC2"}},
+		{ip: "34.81.2.3", expected: []string{"Google Cloud"}},
+		{ip: "20.42.0.1", expected: []string{"AzureStorage"}},
+		{ip: "20.42.1.1", expected: []string{"AzureCloud"}},
+		{ip: "8.8.8.8"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			ranges, ok := table.Lookup(net.ParseIP(tc.ip))
+			if ok != (tc.expected != nil) || len(ranges) != len(tc.expected) {
+				t.Fatalf("unexpected ranges %v", ranges)
+			}
+			for i, r := range ranges {
+				if r.Service != tc.expected[i] {
+					t.Errorf("mismatch (expected: %v, got: %v)", tc.expected, ranges)
+				}
+			}
+		})
+	}
+
+	ip := net.ParseIP("3.5.141.1")
+	ranges, _ := table.Lookup(ip)
+	ranges[0].Service = "changed"
+	_ = append(ranges[:1], Range{Service: "appended"})
+	if again, _ := table.Lookup(ip); len(again) != 2 || again[0].Service != "AMAZON" || again[1].Service != "S3" {
+		t.Errorf("changes of returned ranges reached the table: %v", again)
+	}
+}
+
+func TestNewSet(t *testing.T) {
+	aws, gcp, azure := allRanges(t)
+	ranges := append(append(aws, gcp...), azure...)
+
+	testCases := []struct {
+		filter   Filter
+		ip       string
+		contains bool
+	}{
+		{filter: Filter{}, ip: "34.81.2.3", contains: true},
+		{filter: Filter{Provider: AWS}, ip: "34.81.2.3", contains: false},
+		{filter: Filter{Provider: AWS, Service: "ec2"}, ip: "52.94.76.1", contains: true},
+		{filter: Filter{Provider: AWS, Service: "ec2"}, ip: "52.94.77.1", contains: false},
+		{filter: Filter{Region: "us-west-2"}, ip: "2600:1f14::1", contains: true},
+		{filter: Filter{Region: "EastUS"}, ip: "2603:1030:210::1", contains: true},
+		{filter: Filter{Region: "eastus"}, ip: "13.66.60.119", contains: false},
+	}
+	for _, tc := range testCases {
+		set := NewSet(ranges, tc.filter)
+		if set.Contains(net.ParseIP(tc.ip)) != tc.contains {
+			t.Errorf("unexpected result for %s in %+v", tc.ip, tc.filter)
+		}
+	}
+}
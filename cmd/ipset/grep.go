@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/kentik/ipset"
+)
+
+// grep prints lines holding an ip contained by the set, fails when no line was printed
+func grep(flags *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	invert := flags.Bool("v", false, "print lines not holding ip contained by the set")
+	field := flags.Int("f", 0, "look for ips only in given field, counted from 1")
+	delim := flags.String("d", "", "field delimiter, whitespace by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	if len(args) < 1 {
+		return fmt.Errorf("grep: missing set file")
+	}
+	if *field < 0 {
+		return fmt.Errorf("grep: invalid field %d", *field)
+	}
+
+	set, err := readSet(args[0])
+	if err != nil {
+		return err
+	}
+
+	g := &grepper{set: set, invert: *invert, field: *field, delim: *delim}
+	if len(args) == 1 {
+		// stdin may be a stream like tail -f, lines are printed as they match
+		g.flush = true
+		if err := g.filter(stdin, stdout); err != nil {
+			return fmt.Errorf("grep: %w", err)
+		}
+	}
+	for _, path := range args[1:] {
+		if err := g.filterFile(path, stdout); err != nil {
+			return fmt.Errorf("grep: %w", err)
+		}
+	}
+
+	if !g.matched {
+		return errNotContained
+	}
+
+	return nil
+}
+
+type grepper struct {
+	set     ipset.Set
+	invert  bool
+	field   int
+	delim   string
+	matched bool
+	// flush flushes buffered output after every printed line
+	flush bool
+}
+
+// flusher is implemented by buffered writers
+type flusher interface {
+	Flush() error
+}
+
+func (g *grepper) filterFile(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return g.filter(f, w)
+}
+
+func (g *grepper) filter(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if g.match(line) == g.invert {
+			continue
+		}
+
+		g.matched = true
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		if f, ok := w.(flusher); ok && g.flush {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// match tells whether line, or its selected field, holds an ip contained by the set
+func (g *grepper) match(line string) bool {
+	if g.field > 0 {
+		var fields []string
+		if g.delim == "" {
+			fields = strings.Fields(line)
+		} else {
+			fields = strings.Split(line, g.delim)
+		}
+		if g.field > len(fields) {
+			return false
+		}
+		line = fields[g.field-1]
+	}
+
+	for _, ip := range extractIPs(line) {
+		if g.set.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extractIPs returns ipv4 and ipv6 addresses found in text, ipv4 ones may be followed by :port
+func extractIPs(text string) (ips []net.IP) {
+	isAddrChar := func(c byte) bool {
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' || c == ':' || c == '.'
+	}
+
+	for i := 0; i < len(text); {
+		if !isAddrChar(text[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(text) && isAddrChar(text[i]) {
+			i++
+		}
+		// token glued to preceding word like in "id1.2.3.4" is not an address
+		if start > 0 && isWordChar(text[start-1]) && text[start] != ':' && text[start] != '.' {
+			continue
+		}
+
+		if ip := parseToken(text[start:i]); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'g' && c <= 'z' || c >= 'G' && c <= 'Z' || c == '_'
+}
+
+// parseToken parses run of address characters, tolerating punctuation around it and ipv4 port suffix
+func parseToken(token string) net.IP {
+	if ip := net.ParseIP(token); ip != nil {
+		return ip
+	}
+
+	token = strings.TrimRight(strings.TrimLeft(token, ".:"), ".:")
+	if ip := net.ParseIP(token); ip != nil {
+		return ip
+	}
+
+	if strings.Contains(token, ".") {
+		if i := strings.LastIndexByte(token, ':'); i > 0 {
+			return net.ParseIP(token[:i])
+		}
+	}
+
+	return nil
+}
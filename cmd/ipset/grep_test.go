@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractIPs(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		text     string
+		expected []string
+	}{
+		{
+			desc:     "plain addresses",
+			text:     "10.0.0.1 -> 2001:db8::1",
+			expected: []string{"10.0.0.1", "2001:db8::1"},
+		},
+		{
+			desc:     "punctuation and ports",
+			text:     "src=10.0.0.1:443, dst=[2001:db8::2]:80 host:192.168.0.1.",
+			expected: []string{"10.0.0.1", "2001:db8::2", "192.168.0.1"},
+		},
+		{
+			desc:     "loopback and mapped",
+			text:     "::1 ::ffff:10.0.0.1",
+			expected: []string{"::1", "10.0.0.1"},
+		},
+		{
+			desc: "no addresses",
+			text: "12:34:56 cafe deadbeef 00:11:22:33:44:55 1.2 id1.2.3.4",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ips := extractIPs(tc.text)
+			if len(ips) != len(tc.expected) {
+				t.Fatalf("mismatch (expected: %v, got: %v)", tc.expected, ips)
+			}
+
+			for i, ip := range ips {
+				if ip.String() != tc.expected[i] {
+					t.Errorf("mismatch (expected: %v, got: %v)", tc.expected, ips)
+				}
+			}
+		})
+	}
+}
+
+func TestGrep(t *testing.T) {
+	log := "1 10.0.0.1 8.8.8.8 tcp\n" +
+		"2 8.8.4.4 10.0.0.2 udp\n" +
+		"3 8.8.8.8 2001:db8::1 tcp\n" +
+		"4 1.1.1.1 1.0.0.1 icmp\n"
+
+	testCases := []struct {
+		desc     string
+		args     []string
+		expected string
+		fails    bool
+	}{
+		{
+			desc:     "any field",
+			args:     []string{"grep", "a"},
+			expected: "1 10.0.0.1 8.8.8.8 tcp\n2 8.8.4.4 10.0.0.2 udp\n3 8.8.8.8 2001:db8::1 tcp\n",
+		},
+		{
+			desc:     "inverted",
+			args:     []string{"grep", "-v", "a"},
+			expected: "4 1.1.1.1 1.0.0.1 icmp\n",
+		},
+		{
+			desc:     "selected field",
+			args:     []string{"grep", "-f", "3", "a"},
+			expected: "2 8.8.4.4 10.0.0.2 udp\n3 8.8.8.8 2001:db8::1 tcp\n",
+		},
+		{
+			desc:     "selected field with delimiter",
+			args:     []string{"grep", "-d", " ", "-f", "2", "a"},
+			expected: "1 10.0.0.1 8.8.8.8 tcp\n",
+		},
+		{
+			desc:     "from file",
+			args:     []string{"grep", "-f", "2", "a", "b"},
+			expected: "1 10.0.0.1 8.8.8.8 tcp\n",
+		},
+		{
+			desc:  "no match",
+			args:  []string{"grep", "-f", "4", "a"},
+			fails: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, paths := writeFiles(t, "10.0.0.0/8\n2001:db8::/32\n", log)
+			defer os.RemoveAll(dir)
+			args := make([]string, len(tc.args))
+			for i, arg := range tc.args {
+				switch arg {
+				case "a":
+					args[i] = paths[0]
+				case "b":
+					args[i] = paths[1]
+				default:
+					args[i] = arg
+				}
+			}
+
+			var stdout bytes.Buffer
+			err := run(args, strings.NewReader(log), &stdout)
+			if (err != nil) != tc.fails {
+				t.Fatalf("Unexpected error (fails: %t, err: %v)", tc.fails, err)
+			}
+
+			if got := stdout.String(); got != tc.expected {
+				t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestGrepStream(t *testing.T) {
+	dir, paths := writeFiles(t, "10.0.0.0/8\n")
+	defer os.RemoveAll(dir)
+
+	stdin, input := io.Pipe()
+	output, stdout := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := run([]string{"grep", paths[0]}, stdin, stdout)
+		stdout.Close()
+		done <- err
+	}()
+
+	// input is left open, the match has to show up before more lines arrive
+	go input.Write([]byte("a 10.0.0.1\nb 8.8.8.8\n"))
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(output).ReadString('\n')
+		lines <- line
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "a 10.0.0.1\n" {
+			t.Errorf("unexpected line %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("matching line was not printed before end of input")
+	}
+
+	input.Close()
+	if err := <-done; err != nil {
+		t.Errorf("grep failed: %v", err)
+	}
+}
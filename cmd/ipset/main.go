@@ -0,0 +1,316 @@
+// Command ipset queries and manipulates sets of cidrs kept in plain files, one cidr or ip per line
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/kentik/ipset"
+)
+
+const usage = `usage: ipset <command> [arguments]
+
+commands:
+  contains SET [IP...]       tell whether ips from arguments or stdin are contained by the set
+  aggregate FILE...          print minimal list of cidrs covering all files
+  union FILE...              print cidrs contained by any of files
+  intersect FILE...          print cidrs contained by all of files
+  diff FILE FILE...          print cidrs of the first file not contained by the others
+  stats FILE...              print statistics of every file
+  grep [-v] [-f N] [-d DELIM] SET [FILE...]
+                             print lines of files or stdin holding an ip contained by the set
+                               -v        print lines not holding such ip instead
+                               -f N      look for ips only in N-th field of the line
+                               -d DELIM  field delimiter, whitespace by default
+`
+
+// errNotContained is returned when some of ips are not in the set or no line matched, it only sets exit code
+var errNotContained = errors.New("not contained")
+
+// command parses its flags with given flag set and runs
+type command func(flags *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error
+
+var commands = map[string]command{
+	"contains":  contains,
+	"aggregate": union,
+	"union":     union,
+	"intersect": intersect,
+	"diff":      diff,
+	"stats":     stats,
+	"grep":      grep,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if err != errNotContained {
+			fmt.Fprintln(os.Stderr, "ipset:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", usage)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+	}
+
+	w := bufio.NewWriter(stdout)
+	if err := cmd(flags, args[1:], stdin, w); err != nil {
+		w.Flush()
+		return err
+	}
+
+	return w.Flush()
+}
+
+// contains prints every ip followed by true or false, fails when any of them is not contained
+func contains(flags *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	if len(args) < 1 {
+		return fmt.Errorf("contains: missing set file")
+	}
+
+	set, err := readSet(args[0])
+	if err != nil {
+		return err
+	}
+
+	allContained := true
+	check := func(raw string) error {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return fmt.Errorf("contains: invalid ip %q", raw)
+		}
+
+		contained := set.Contains(ip)
+		allContained = allContained && contained
+		_, err := fmt.Fprintf(stdout, "%s\t%t\n", raw, contained)
+		return err
+	}
+
+	if len(args) > 1 {
+		for _, raw := range args[1:] {
+			if err := check(raw); err != nil {
+				return err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				if err := check(line); err != nil {
+					return err
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("contains: %w", err)
+		}
+	}
+
+	if !allContained {
+		return errNotContained
+	}
+
+	return nil
+}
+
+// union prints minimal list of cidrs covering all files, it serves aggregate as well
+func union(flags *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	sets, err := readSets(args, 1)
+	if err != nil {
+		return err
+	}
+
+	result := sets[0]
+	for _, set := range sets[1:] {
+		for _, cidr := range set.Prefixes() {
+			result.Add(cidr)
+		}
+	}
+
+	return writeSet(stdout, result)
+}
+
+func intersect(flags *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	sets, err := readSets(args, 2)
+	if err != nil {
+		return err
+	}
+
+	result := sets[0]
+	for _, set := range sets[1:] {
+		// a ∩ b = a \ (a \ b)
+		excluded := ipset.NewTree(result.Prefixes()...)
+		for _, cidr := range set.Prefixes() {
+			excluded.Remove(cidr)
+		}
+		for _, cidr := range excluded.Prefixes() {
+			result.Remove(cidr)
+		}
+	}
+
+	return writeSet(stdout, result)
+}
+
+func diff(flags *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	sets, err := readSets(args, 2)
+	if err != nil {
+		return err
+	}
+
+	result := sets[0]
+	for _, set := range sets[1:] {
+		for _, cidr := range set.Prefixes() {
+			result.Remove(cidr)
+		}
+	}
+
+	return writeSet(stdout, result)
+}
+
+func stats(flags *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	sets, err := readSets(args, 1)
+	if err != nil {
+		return err
+	}
+
+	for i, set := range sets {
+		st := ipset.Stats(set)
+		ipv4Prefixes, ipv4Addrs := histogramTotals(st.IPv4Prefixes[:], 32)
+		ipv6Prefixes, ipv6Addrs := histogramTotals(st.IPv6Prefixes[:], 128)
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s\n  prefixes: %d\n  ipv4 prefixes: %d\n  ipv6 prefixes: %d\n  ipv4 addresses: %s\n  ipv6 addresses: %s\n"+
+			"  nodes: %d\n  max depth: %d\n  avg depth: %.2f\n",
+			args[i], st.Leaves, ipv4Prefixes, ipv6Prefixes, ipv4Addrs, ipv6Addrs,
+			st.Nodes, st.MaxDepth, st.AvgDepth)
+		writeHistogram(&b, "ipv4", st.IPv4Prefixes[:])
+		writeHistogram(&b, "ipv6", st.IPv6Prefixes[:])
+		fmt.Fprintf(&b, "  memory bytes: %d\n", st.MemoryBytes)
+
+		if _, err := io.WriteString(stdout, b.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// histogramTotals returns number of prefixes in histogram by prefix length and number of addresses they cover
+func histogramTotals(histogram []int, bits int) (int, *big.Int) {
+	prefixes, addrs := 0, new(big.Int)
+	for ones, count := range histogram {
+		size := new(big.Int).Lsh(big.NewInt(int64(count)), uint(bits-ones))
+		addrs.Add(addrs, size)
+		prefixes += count
+	}
+
+	return prefixes, addrs
+}
+
+// writeHistogram writes a line for every prefix length present in histogram
+func writeHistogram(b *strings.Builder, family string, histogram []int) {
+	for ones, count := range histogram {
+		if count != 0 {
+			fmt.Fprintf(b, "  %s /%d: %d\n", family, ones, count)
+		}
+	}
+}
+
+func readSets(paths []string, atLeast int) ([]ipset.Tree, error) {
+	if len(paths) < atLeast {
+		return nil, fmt.Errorf("expected at least %d set files, got %d", atLeast, len(paths))
+	}
+
+	sets := make([]ipset.Tree, len(paths))
+	for i, path := range paths {
+		set, err := readSet(path)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	return sets, nil
+}
+
+// readSet reads file with cidr or ip per line, empty lines and lines starting with # are skipped
+func readSet(path string) (ipset.Tree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cidrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cidrs = append(cidrs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	set, err := ipset.NewSetFromCSV(strings.Join(cidrs, ","))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return set.(ipset.Tree), nil
+}
+
+func writeSet(w io.Writer, set ipset.Tree) error {
+	for _, cidr := range set.Prefixes() {
+		if _, err := fmt.Fprintln(w, cidr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes contents into files of a new temporary directory, which the caller removes
+func writeFiles(t *testing.T, contents ...string) (string, []string) {
+	dir, err := ioutil.TempDir("", "ipset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(contents))
+	for i, content := range contents {
+		paths[i] = filepath.Join(dir, string(rune('a'+i))+".txt")
+		if err := ioutil.WriteFile(paths[i], []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir, paths
+}
+
+var memoryBytes = regexp.MustCompile(`memory bytes: \d+`)
+
+func TestRun(t *testing.T) {
+	files := []string{
+		"# office\n10.0.0.0/24\n10.0.1.0/24\n\n192.168.0.0/16\n2001:db8::/32\n",
+		"10.0.1.128/25\n192.168.10.1\n172.16.0.0/12\n",
+	}
+
+	testCases := []struct {
+		desc     string
+		args     []string
+		stdin    string
+		expected string
+		fails    bool
+	}{
+		{
+			desc:     "aggregate",
+			args:     []string{"aggregate", "a"},
+			expected: "10.0.0.0/23\n192.168.0.0/16\n2001:db8::/32\n",
+		},
+		{
+			desc:     "union",
+			args:     []string{"union", "a", "b"},
+			expected: "10.0.0.0/23\n172.16.0.0/12\n192.168.0.0/16\n2001:db8::/32\n",
+		},
+		{
+			desc:     "intersect",
+			args:     []string{"intersect", "a", "b"},
+			expected: "10.0.1.128/25\n192.168.10.1/32\n",
+		},
+		{
+			desc:     "diff",
+			args:     []string{"diff", "b", "a"},
+			expected: "172.16.0.0/12\n",
+		},
+		{
+			desc:     "contains from arguments",
+			args:     []string{"contains", "a", "10.0.1.1", "2001:db8::1"},
+			expected: "10.0.1.1\ttrue\n2001:db8::1\ttrue\n",
+		},
+		{
+			desc:     "contains from stdin",
+			args:     []string{"contains", "a"},
+			stdin:    "10.0.1.1\n\n10.0.2.1\n",
+			expected: "10.0.1.1\ttrue\n10.0.2.1\tfalse\n",
+			fails:    true,
+		},
+		{
+			desc: "stats",
+			args: []string{"stats", "b"},
+			expected: "b\n  prefixes: 3\n  ipv4 prefixes: 3\n  ipv6 prefixes: 0\n  ipv4 addresses: 1048705\n  ipv6 addresses: 0\n" +
+				"  nodes: 5\n  max depth: 2\n  avg depth: 1.67\n  ipv4 /12: 1\n  ipv4 /25: 1\n  ipv4 /32: 1\n  memory bytes: N\n",
+		},
+		{
+			desc: "stats both families",
+			args: []string{"stats", "a"},
+			expected: "a\n  prefixes: 3\n  ipv4 prefixes: 2\n  ipv6 prefixes: 1\n  ipv4 addresses: 66048\n" +
+				"  ipv6 addresses: 79228162514264337593543950336\n  nodes: 5\n  max depth: 2\n  avg depth: 1.67\n" +
+				"  ipv4 /16: 1\n  ipv4 /23: 1\n  ipv6 /32: 1\n  memory bytes: N\n",
+		},
+		{
+			desc:  "invalid ip",
+			args:  []string{"contains", "a", "10.0.0"},
+			fails: true,
+		},
+		{
+			desc:  "missing file",
+			args:  []string{"diff", "a"},
+			fails: true,
+		},
+		{
+			desc:  "unknown command",
+			args:  []string{"foo"},
+			fails: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, paths := writeFiles(t, files...)
+			defer os.RemoveAll(dir)
+			args := make([]string, len(tc.args))
+			for i, arg := range tc.args {
+				switch arg {
+				case "a":
+					args[i] = paths[0]
+				case "b":
+					args[i] = paths[1]
+				default:
+					args[i] = arg
+				}
+			}
+
+			var stdout bytes.Buffer
+			err := run(args, strings.NewReader(tc.stdin), &stdout)
+			if (err != nil) != tc.fails {
+				t.Fatalf("Unexpected error (fails: %t, err: %v)", tc.fails, err)
+			}
+
+			got := strings.Replace(stdout.String(), paths[0], "a", -1)
+			got = strings.Replace(got, paths[1], "b", -1)
+			// memory estimate depends on platform
+			got = memoryBytes.ReplaceAllString(got, "memory bytes: N")
+			if tc.expected != "" && got != tc.expected {
+				t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, got)
+			}
+		})
+	}
+}
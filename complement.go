@@ -0,0 +1,127 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// ipv4All is the key of 0.0.0.0/0, ipv4 addresses are stored in ::ffff:0:0/96
+var ipv4All = treeNode{addr: ipv4Space, prefix: 96}
+
+// Complement returns prefixes not covered by the set, ipv4 and ipv6 spaces are complemented separately
+// and only when the set holds addresses of the family, so complement of ipv4 only set holds no ipv6 prefixes
+func Complement(t Tree) Tree {
+	s := treeOf(t)
+	hasIPv4, hasIPv6 := false, false
+	s.walk(func(addr uint128.Uint128, prefix uint32) {
+		matching := matchingPrefix(addr, ipv4Space)
+		inIPv4 := prefix >= 96 && matching >= 96
+		enclosesIPv4 := prefix < 96 && matching >= prefix
+
+		hasIPv4 = hasIPv4 || inIPv4 || enclosesIPv4
+		hasIPv6 = hasIPv6 || !inIPv4
+	})
+
+	var b Builder
+	if hasIPv4 {
+		s.complement(&b, ipv4All)
+	}
+	if hasIPv6 {
+		s.complement(&b, treeNode{})
+	}
+
+	return b.Build()
+}
+
+// ComplementWithin returns prefixes of universe not covered by the set. Ipv6 universe
+// never includes ipv4 space, even when enclosing ::ffff:0:0/96. Nil or invalid universe is an error.
+func ComplementWithin(t Tree, universe *net.IPNet) (Tree, error) {
+	node, err := leafFromNet(universe)
+	if err != nil {
+		return nil, fmt.Errorf("ComplementWithin: %w", err)
+	}
+
+	var b Builder
+	treeOf(t).complement(&b, node)
+
+	return b.Build(), nil
+}
+
+// complement adds prefixes of universe not covered by the set to b
+func (s *ipset) complement(b *Builder, universe treeNode) {
+	for _, r := range universeRanges(universe) {
+		s.gaps(r[0], r[1], func(start, end uint128.Uint128) bool {
+			rangeToPrefixes(start, end, func(addr uint128.Uint128, prefix uint32) {
+				b.add(treeNode{addr: addr, prefix: prefix})
+			})
+			return true
+		})
+	}
+}
+
+// universeRanges returns address range of universe, ipv6 universe enclosing ipv4 space is split around it
+func universeRanges(universe treeNode) [][2]uint128.Uint128 {
+	start, end := universe.addr, universe.addr.Or(hostMask(universe.prefix))
+	if universe.prefix >= 96 || matchingPrefix(universe.addr, ipv4Space) < universe.prefix {
+		return [][2]uint128.Uint128{{start, end}}
+	}
+
+	var ranges [][2]uint128.Uint128
+	if ipv4Space.Cmp(start) > 0 {
+		ranges = append(ranges, [2]uint128.Uint128{start, ipv4Space.Sub64(1)})
+	}
+	if last := ipv4Space.Or(hostMask(96)); last.Cmp(end) < 0 {
+		ranges = append(ranges, [2]uint128.Uint128{last.Add64(1), end})
+	}
+
+	return ranges
+}
+
+// gaps calls fn for every interval within [start, end] not covered by the set, in ascending order,
+// until fn returns false
+func (s *ipset) gaps(start, end uint128.Uint128, fn func(start, end uint128.Uint128) bool) {
+	next, done := start, false
+	s.walkRange(start, end, func(addr uint128.Uint128, prefix uint32) bool {
+		if addr.Cmp(next) > 0 && !fn(next, addr.Sub64(1)) {
+			done = true
+			return false
+		}
+
+		last := addr.Or(hostMask(prefix))
+		if last.Cmp(end) >= 0 {
+			done = true
+			return false
+		}
+		next = last.Add64(1)
+		return true
+	})
+
+	if !done {
+		fn(next, end)
+	}
+}
+
+// walkRange is walk skipping subtrees out of [start, end] range, it stops when fn returns false
+func (s *ipset) walkRange(start, end uint128.Uint128, fn func(addr uint128.Uint128, prefix uint32) bool) {
+	var visit func(i, offset uint32) bool
+	visit = func(i, offset uint32) bool {
+		n := &s.nodes[i]
+		offset += n.prefix
+		first := maskAddr(n.addr, offset)
+		if first.Cmp(end) > 0 || first.Or(hostMask(offset)).Cmp(start) < 0 {
+			return true
+		}
+
+		if n.left == 0 {
+			return fn(first, offset)
+		}
+
+		return visit(n.left, offset) && visit(n.right, offset)
+	}
+
+	if s.root != 0 {
+		visit(s.root, 0)
+	}
+}
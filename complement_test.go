@@ -0,0 +1,146 @@
+package ipset
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func prefixStrings(t Tree) string {
+	var res []string
+	for _, p := range t.Prefixes() {
+		res = append(res, p.String())
+	}
+
+	return strings.Join(res, " ")
+}
+
+func TestComplement(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		cidrs    []*net.IPNet
+		expected string
+	}{
+		{
+			desc:  "empty",
+			cidrs: nil,
+		},
+		{
+			desc:     "ipv4 only",
+			cidrs:    parseCidrs("0.0.0.0/1", "192.0.0.0/2"),
+			expected: "128.0.0.0/2",
+		},
+		{
+			desc:     "ipv6 only excludes ipv4 space",
+			cidrs:    parseCidrs("8000::/1", "::/2", "6000::/3"),
+			expected: "4000::/3",
+		},
+		{
+			desc:     "ipv6 prefix enclosing ipv4 space",
+			cidrs:    parseCidrs("::/1"),
+			expected: "8000::/1",
+		},
+		{
+			desc:     "both families",
+			cidrs:    parseCidrs("0.0.0.0/1", "::/1"),
+			expected: "8000::/1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := prefixStrings(Complement(NewTree(tc.cidrs...)))
+			if got != tc.expected {
+				t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, got)
+			}
+		})
+	}
+
+	complement := Complement(NewTree(parseCidrs("128.0.0.0/1", "8000::/1")...))
+	for ip, expected := range map[string]bool{
+		"1.2.3.4":     true,
+		"200.1.1.1":   false,
+		"2001:db8::1": true,
+		"8000::1":     false,
+		"::1.2.3.4":   true,
+	} {
+		if complement.Contains(net.ParseIP(ip)) != expected {
+			t.Errorf("unexpected result for %s", ip)
+		}
+	}
+}
+
+func TestComplementWithin(t *testing.T) {
+	set := NewTree(parseCidrs("10.0.0.0/24", "10.0.2.0/23", "10.0.0.0/8", "192.168.1.0/24", "2001:db8:8000::/33", "200::/8")...)
+	testCases := []struct {
+		universe string
+		expected string
+	}{
+		{universe: "192.168.0.0/22", expected: "192.168.0.0/24 192.168.2.0/23"},
+		{universe: "192.168.1.0/25", expected: ""},
+		{universe: "10.1.0.0/16", expected: ""},
+		{universe: "172.16.0.0/12", expected: "172.16.0.0/12"},
+		{universe: "2001:db8::/32", expected: "2001:db8::/33"},
+		{universe: "200::/7", expected: "300::/8"},
+		{universe: "0.0.0.0/0", expected: "0.0.0.0/5 8.0.0.0/7 11.0.0.0/8 12.0.0.0/6 16.0.0.0/4 32.0.0.0/3 64.0.0.0/2 " +
+			"128.0.0.0/2 192.0.0.0/9 192.128.0.0/11 192.160.0.0/13 192.168.0.0/24 192.168.2.0/23 192.168.4.0/22 " +
+			"192.168.8.0/21 192.168.16.0/20 192.168.32.0/19 192.168.64.0/18 192.168.128.0/17 192.169.0.0/16 " +
+			"192.170.0.0/15 192.172.0.0/14 192.176.0.0/12 192.192.0.0/10 193.0.0.0/8 194.0.0.0/7 196.0.0.0/6 " +
+			"200.0.0.0/5 208.0.0.0/4 224.0.0.0/3"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.universe, func(t *testing.T) {
+			complement, err := ComplementWithin(set, parseCidrs(tc.universe)[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := prefixStrings(complement); got != tc.expected {
+				t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, got)
+			}
+		})
+	}
+	ipv6, err := ComplementWithin(set, parseCidrs("::/0")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipv6.Contains(net.ParseIP("1.2.3.4")) || !ipv6.Contains(net.ParseIP("::1.2.3.4")) || ipv6.Contains(net.ParseIP("2001:db8:8000::1")) {
+		t.Errorf("ipv6 complement is not limited to ipv6 space")
+	}
+
+	for _, universe := range []*net.IPNet{nil, {}} {
+		if _, err := ComplementWithin(set, universe); err == nil {
+			t.Errorf("complement within invalid universe %v was returned", universe)
+		}
+	}
+}
+
+func TestComplementRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cidrs := denseIPv4Cidrs(rng, 300)
+	set := NewTree(cidrs...)
+	universe := parseCidrs("10.0.0.0/20")[0]
+	complement, err := ComplementWithin(set, universe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1<<12; i++ {
+		ip := net.IPv4(10, 0, byte(i>>8), byte(i))
+		if set.Contains(ip) == complement.Contains(ip) {
+			t.Fatalf("%s is in both or none of the set and its complement", ip)
+		}
+	}
+	if complement.Contains(net.ParseIP("10.0.16.0")) || complement.Contains(net.ParseIP("9.255.255.255")) {
+		t.Errorf("complement exceeds universe")
+	}
+
+	// complement of the complement within the same universe is the set clipped to it
+	back, err := ComplementWithin(complement, universe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NewTree(cidrs...)
+	if err := sameTree(expected.(*ipset), back.(*ipset)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,250 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// Delta holds changes turning one version of a set into another, prefixes are disjoint and in minimal form
+type Delta struct {
+	Added   []*net.IPNet
+	Removed []*net.IPNet
+}
+
+// NewDelta returns Delta turning old set into new one
+func NewDelta(old, new Set) (*Delta, error) {
+	added, removed, err := Diff(old, new)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Delta{Added: added, Removed: removed}, nil
+}
+
+// Diff returns minimal lists of prefixes covered only by new and only by old set, in ascending order.
+// Both sets are walked together, they have to be created by this package or list their prefixes.
+// Other sets, able to tell only whether they contain an address, can't be diffed and are an error.
+func Diff(old, new Set) (added, removed []*net.IPNet, err error) {
+	nextOld, err := leafIterator(old)
+	if err != nil {
+		return nil, nil, err
+	}
+	nextNew, err := leafIterator(new)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var addedRanges, removedRanges rangeList
+
+	o, oOk := nextOld()
+	n, nOk := nextNew()
+	for oOk || nOk {
+		switch {
+		case !nOk || oOk && o[1].Cmp(n[0]) < 0:
+			removedRanges.add(o[0], o[1])
+			o, oOk = nextOld()
+		case !oOk || n[1].Cmp(o[0]) < 0:
+			addedRanges.add(n[0], n[1])
+			n, nOk = nextNew()
+		case o[0].Cmp(n[0]) < 0:
+			removedRanges.add(o[0], n[0].Sub64(1))
+			o[0] = n[0]
+		case n[0].Cmp(o[0]) < 0:
+			addedRanges.add(n[0], o[0].Sub64(1))
+			n[0] = o[0]
+		default:
+			// common part, whatever sticks out remains for next round
+			switch o[1].Cmp(n[1]) {
+			case 0:
+				o, oOk = nextOld()
+				n, nOk = nextNew()
+			case -1:
+				n[0] = o[1].Add64(1)
+				o, oOk = nextOld()
+			default:
+				o[0] = n[1].Add64(1)
+				n, nOk = nextNew()
+			}
+		}
+	}
+
+	return addedRanges.prefixes(), removedRanges.prefixes(), nil
+}
+
+// leafIterator returns function yielding [first, last] ranges of prefixes stored in the set in ascending order
+func leafIterator(s Set) (func() ([2]uint128.Uint128, bool), error) {
+	var w prefixWalker
+	switch t := s.(type) {
+	case *ipset:
+		return t.iterator(), nil
+	case prefixWalker:
+		w = t
+	case interface{ Prefixes() []*net.IPNet }:
+		// prefixes of other implementations may overlap or come in any order
+		return treeOf(NewTree(t.Prefixes()...)).iterator(), nil
+	default:
+		return nil, fmt.Errorf("Diff: unsupported set type %T", s)
+	}
+
+	var ranges [][2]uint128.Uint128
+	w.walk(func(addr uint128.Uint128, prefix uint32) {
+		ranges = append(ranges, [2]uint128.Uint128{addr, addr.Or(hostMask(prefix))})
+	})
+
+	return func() ([2]uint128.Uint128, bool) {
+		if len(ranges) == 0 {
+			return [2]uint128.Uint128{}, false
+		}
+		r := ranges[0]
+		ranges = ranges[1:]
+		return r, true
+	}, nil
+}
+
+// iterator is walk turned inside out, it keeps explicit stack of nodes to visit
+func (s *ipset) iterator() func() ([2]uint128.Uint128, bool) {
+	type frame struct {
+		index, offset uint32
+	}
+
+	var stack []frame
+	if s.root != 0 {
+		stack = append(stack, frame{s.root, 0})
+	}
+
+	return func() ([2]uint128.Uint128, bool) {
+		for len(stack) > 0 {
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			n := &s.nodes[f.index]
+			offset := f.offset + n.prefix
+			if n.left == 0 {
+				first := maskAddr(n.addr, offset)
+				return [2]uint128.Uint128{first, first.Or(hostMask(offset))}, true
+			}
+
+			stack = append(stack, frame{n.right, offset}, frame{n.left, offset})
+		}
+
+		return [2]uint128.Uint128{}, false
+	}
+}
+
+// rangeList collects ascending ranges merging adjacent ones
+type rangeList [][2]uint128.Uint128
+
+func (l *rangeList) add(start, end uint128.Uint128) {
+	if n := len(*l); n > 0 && (*l)[n-1][1].Add64(1).Equals(start) {
+		(*l)[n-1][1] = end
+		return
+	}
+
+	*l = append(*l, [2]uint128.Uint128{start, end})
+}
+
+func (l rangeList) prefixes() []*net.IPNet {
+	var prefixes []*net.IPNet
+	for _, r := range l {
+		rangeToPrefixes(r[0], r[1], func(addr uint128.Uint128, prefix uint32) {
+			prefixes = append(prefixes, netFromAddr(addr, prefix))
+		})
+	}
+
+	return prefixes
+}
+
+// Apply patches the tree in place with delta
+func (d *Delta) Apply(t Tree) {
+	for _, cidr := range d.Removed {
+		t.Remove(cidr)
+	}
+	for _, cidr := range d.Added {
+		t.Add(cidr)
+	}
+}
+
+// deltaMagic starts serialized delta, its last byte is format version
+var deltaMagic = []byte{'I', 'P', 'S', 'D', 1}
+
+var errInvalidDelta = errors.New("invalid delta")
+
+// MarshalBinary serializes delta as magic followed by removed and added lists, each list is uvarint count
+// of prefixes followed by their length byte and significant bytes of address. Lengths of ipv6 prefixes
+// are stored increased by 33 to tell them apart from ipv4 ones.
+func (d *Delta) MarshalBinary() ([]byte, error) {
+	buf := append([]byte{}, deltaMagic...)
+	for _, list := range [][]*net.IPNet{d.Removed, d.Added} {
+		var count [binary.MaxVarintLen64]byte
+		buf = append(buf, count[:binary.PutUvarint(count[:], uint64(len(list)))]...)
+
+		for _, cidr := range list {
+			ones, bits := cidr.Mask.Size()
+			ip := cidr.IP.To4()
+			switch {
+			case bits == 32 && ip != nil:
+				buf = append(buf, byte(ones))
+			case bits == 128 && cidr.IP.To16() != nil:
+				ip = cidr.IP.To16()
+				buf = append(buf, byte(ones+33))
+			default:
+				return nil, fmt.Errorf("Delta: invalid prefix %s", cidr)
+			}
+			buf = append(buf, ip.Mask(cidr.Mask)[:(ones+7)/8]...)
+		}
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary restores delta serialized by MarshalBinary
+func (d *Delta) UnmarshalBinary(data []byte) error {
+	if len(data) < len(deltaMagic) || string(data[:len(deltaMagic)]) != string(deltaMagic) {
+		return fmt.Errorf("Delta: %w: unknown format", errInvalidDelta)
+	}
+	data = data[len(deltaMagic):]
+
+	var lists [2][]*net.IPNet
+	for i := range lists {
+		count, n := binary.Uvarint(data)
+		if n <= 0 || count > uint64(len(data)) {
+			return fmt.Errorf("Delta: %w: bad prefix count", errInvalidDelta)
+		}
+		data = data[n:]
+
+		lists[i] = make([]*net.IPNet, 0, count)
+		for ; count > 0; count-- {
+			if len(data) == 0 {
+				return fmt.Errorf("Delta: %w: truncated", errInvalidDelta)
+			}
+
+			ones, bits := int(data[0]), 32
+			if ones > 32 {
+				ones, bits = ones-33, 128
+			}
+			size := (ones + 7) / 8
+			if ones > bits || len(data) < 1+size {
+				return fmt.Errorf("Delta: %w: truncated", errInvalidDelta)
+			}
+
+			ip := make(net.IP, bits/8)
+			copy(ip, data[1:1+size])
+			mask := net.CIDRMask(ones, bits)
+			if !ip.Mask(mask).Equal(ip) {
+				return fmt.Errorf("Delta: %w: host bits set in %s/%d", errInvalidDelta, ip, ones)
+			}
+			lists[i] = append(lists[i], &net.IPNet{IP: ip, Mask: mask})
+			data = data[1+size:]
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("Delta: %w: trailing data", errInvalidDelta)
+	}
+
+	d.Removed, d.Added = lists[0], lists[1]
+	return nil
+}
@@ -0,0 +1,193 @@
+package ipset
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func netStrings(cidrs []*net.IPNet) string {
+	var res []string
+	for _, cidr := range cidrs {
+		res = append(res, cidr.String())
+	}
+
+	return strings.Join(res, " ")
+}
+
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		old     []*net.IPNet
+		new     []*net.IPNet
+		added   string
+		removed string
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc:  "from empty",
+			new:   parseCidrs("10.0.0.0/8", "2001:db8::/32"),
+			added: "10.0.0.0/8 2001:db8::/32",
+		},
+		{
+			desc:    "to empty",
+			old:     parseCidrs("10.0.0.0/8"),
+			removed: "10.0.0.0/8",
+		},
+		{
+			desc: "same",
+			old:  parseCidrs("10.0.0.0/8", "192.168.0.0/16"),
+			new:  parseCidrs("192.168.0.0/17", "10.0.0.0/8", "192.168.128.0/17"),
+		},
+		{
+			desc:    "split block",
+			old:     parseCidrs("10.0.0.0/8"),
+			new:     parseCidrs("10.0.0.0/9", "10.192.0.0/10", "11.0.0.0/8"),
+			added:   "11.0.0.0/8",
+			removed: "10.128.0.0/10",
+		},
+		{
+			desc:    "overlapping",
+			old:     parseCidrs("10.0.0.0/24", "10.0.2.0/24"),
+			new:     parseCidrs("10.0.1.0/24", "10.0.2.128/25"),
+			added:   "10.0.1.0/24",
+			removed: "10.0.0.0/24 10.0.2.0/25",
+		},
+		{
+			desc:    "adjacent changes merged",
+			old:     parseCidrs("10.0.0.0/24", "10.0.2.0/24"),
+			new:     parseCidrs("10.0.1.0/24", "10.0.3.0/24"),
+			added:   "10.0.1.0/24 10.0.3.0/24",
+			removed: "10.0.0.0/24 10.0.2.0/24",
+		},
+		{
+			desc:    "whole space",
+			old:     parseCidrs("::/0"),
+			new:     parseCidrs("::/1"),
+			removed: "8000::/1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			added, removed, err := Diff(NewSet(tc.old...), NewSet(tc.new...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := netStrings(added); got != tc.added {
+				t.Errorf("added mismatch (expected: %q, got: %q)", tc.added, got)
+			}
+			if got := netStrings(removed); got != tc.removed {
+				t.Errorf("removed mismatch (expected: %q, got: %q)", tc.removed, got)
+			}
+		})
+	}
+}
+
+func TestDiffRangeSet(t *testing.T) {
+	r, err := NewRangeSet(NewSet(parseCidrs("10.0.0.0/23")...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, removed, err := Diff(r, NewSet(parseCidrs("10.0.1.0/24")...))
+	if err != nil || len(added) != 0 || netStrings(removed) != "10.0.0.0/24" {
+		t.Errorf("unexpected diff %v %v (err: %v)", added, removed, err)
+	}
+}
+
+// containsAll is a Set unable to list its prefixes
+type containsAll struct{}
+
+func (containsAll) Contains(net.IP) bool        { return true }
+func (containsAll) ContainsRawIPv4(uint32) bool { return true }
+
+func TestDiffOtherSets(t *testing.T) {
+	// tree of other implementation lists overlapping prefixes
+	other := struct{ Tree }{NewTree(parseCidrs("10.0.0.0/24")...)}
+	added, removed, err := Diff(other, NewSet(parseCidrs("10.0.0.0/23")...))
+	if err != nil || netStrings(added) != "10.0.1.0/24" || len(removed) != 0 {
+		t.Errorf("unexpected diff %v %v (err: %v)", added, removed, err)
+	}
+
+	if _, _, err := Diff(containsAll{}, NewSet()); err == nil {
+		t.Errorf("set without prefixes was diffed")
+	}
+	if _, err := NewDelta(NewSet(), containsAll{}); err == nil {
+		t.Errorf("delta of set without prefixes was created")
+	}
+}
+
+func TestDeltaRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		old := NewTree(denseIPv4Cidrs(rng, rng.Intn(100))...)
+		new := NewTree(denseIPv4Cidrs(rng, rng.Intn(100))...)
+		for _, cidr := range randomIPv6Cidrs(rng, rng.Intn(10)) {
+			old.Add(cidr)
+			new.Add(cidr)
+		}
+		new.Add(randomIPv6Cidrs(rng, 1)[0])
+
+		d, err := NewDelta(old, new)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		var delta Delta
+		if err := delta.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary failed: %v", err)
+		}
+
+		delta.Apply(old)
+		if err := sameTree(old.(*ipset), new.(*ipset)); err != nil {
+			t.Fatalf("patched set differs: %v", err)
+		}
+		if added, removed, err := Diff(old, new); err != nil || len(added) != 0 || len(removed) != 0 {
+			t.Fatalf("patched set differs: %v %v (err: %v)", added, removed, err)
+		}
+	}
+}
+
+func TestDeltaBinary(t *testing.T) {
+	delta := &Delta{
+		Added:   parseCidrs("10.0.0.0/8", "192.168.1.128/25", "0.0.0.0/0"),
+		Removed: parseCidrs("2001:db8::/32", "::/0", "::1/128"),
+	}
+	data, err := delta.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// magic, two counts, 3 length bytes per list and address bytes
+	if expected := 5 + 2 + 6 + (1 + 4 + 0) + (4 + 0 + 16); len(data) != expected {
+		t.Errorf("unexpected size (expected: %d, got: %d)", expected, len(data))
+	}
+
+	var got Delta
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if netStrings(got.Added) != netStrings(delta.Added) || netStrings(got.Removed) != netStrings(delta.Removed) {
+		t.Errorf("round trip mismatch (expected: %v, got: %v)", delta, got)
+	}
+
+	for _, invalid := range [][]byte{
+		nil,
+		[]byte("IPSD\x02\x00\x00"),
+		append(append([]byte{}, data...), 0),
+		data[:len(data)-1],
+		[]byte("IPSD\x01\x01\x07\x0b\x00"),
+		[]byte("IPSD\x01\x01\xff\x00"),
+		[]byte("IPSD\x01\x01\x18\x0a\x00"),
+	} {
+		if err := got.UnmarshalBinary(invalid); !errors.Is(err, errInvalidDelta) {
+			t.Errorf("unexpected error for %q: %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ipset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteDOT writes the tree as Graphviz digraph, nodes are labeled with their prefix, increment over
+// the parent's offset and cumulative prefix length in the key space, edges with the child side
+func WriteDOT(w io.Writer, t Tree) error {
+	s := treeOf(t)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph ipset {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	s.dump(func(i, parent uint32, side string, offset uint32, depth int) {
+		n := &s.nodes[i]
+		shape := ""
+		if n.left == 0 {
+			shape = ", shape=ellipse"
+		}
+		fmt.Fprintf(bw, "\tn%d [label=\"%s\\n+%d = %d\"%s];\n", i, netFromAddr(maskAddr(n.addr, offset), offset), n.prefix, offset, shape)
+		if parent != 0 {
+			fmt.Fprintf(bw, "\tn%d -> n%d [label=\"%s\"];\n", parent, i, side)
+		}
+	})
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// Dump writes the tree as indented list of nodes, one per line, children follow their parent
+// marked with L or R
+func Dump(w io.Writer, t Tree) error {
+	s := treeOf(t)
+	bw := bufio.NewWriter(w)
+	s.dump(func(i, _ uint32, side string, offset uint32, depth int) {
+		n := &s.nodes[i]
+		leaf := ""
+		if n.left == 0 {
+			leaf = " leaf"
+		}
+		fmt.Fprintf(bw, "%s%s %s prefix=+%d cumulative=%d%s\n",
+			strings.Repeat("  ", depth), side, netFromAddr(maskAddr(n.addr, offset), offset), n.prefix, offset, leaf)
+	})
+
+	return bw.Flush()
+}
+
+// dump calls fn for every node in pre-order with index of its parent, side it hangs on,
+// its cumulative prefix length and depth
+func (s *ipset) dump(fn func(i, parent uint32, side string, offset uint32, depth int)) {
+	var visit func(i, parent uint32, side string, offset uint32, depth int)
+	visit = func(i, parent uint32, side string, offset uint32, depth int) {
+		n := &s.nodes[i]
+		offset += n.prefix
+		fn(i, parent, side, offset, depth)
+		if n.left != 0 {
+			visit(n.left, i, "L", offset, depth+1)
+			visit(n.right, i, "R", offset, depth+1)
+		}
+	}
+
+	if s.root != 0 {
+		visit(s.root, 0, "root", 0, 0)
+	}
+}
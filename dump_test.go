@@ -0,0 +1,53 @@
+package ipset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	set := NewTree(parseCidrs("10.0.0.0/8", "192.168.0.0/24", "192.168.1.0/25", "2001:db8::/32")...)
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, set); err != nil {
+		t.Fatal(err)
+	}
+	expected := `root ::/2 prefix=+2 cumulative=2
+  L 0.0.0.0/0 prefix=+94 cumulative=96
+    L 10.0.0.0/8 prefix=+8 cumulative=104 leaf
+    R 192.168.0.0/23 prefix=+23 cumulative=119
+      L 192.168.0.0/24 prefix=+1 cumulative=120 leaf
+      R 192.168.1.0/25 prefix=+2 cumulative=121 leaf
+  R 2001:db8::/32 prefix=+30 cumulative=32 leaf
+`
+	if buf.String() != expected {
+		t.Errorf("mismatch (expected: %q, got: %q)", expected, buf.String())
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, NewTree()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "digraph ipset {\n\tnode [shape=box];\n}\n"; buf.String() != expected {
+		t.Errorf("mismatch (expected: %q, got: %q)", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteDOT(&buf, NewTree(parseCidrs("10.0.0.0/24", "10.0.1.0/25")...)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph ipset {
+	node [shape=box];
+	n1 [label="10.0.0.0/23\n+119 = 119"];
+	n3 [label="10.0.0.0/24\n+1 = 120", shape=ellipse];
+	n1 -> n3 [label="L"];
+	n2 [label="10.0.1.0/25\n+2 = 121", shape=ellipse];
+	n1 -> n2 [label="R"];
+}
+`
+	if buf.String() != expected {
+		t.Errorf("mismatch (expected: %q, got: %q)", expected, buf.String())
+	}
+}
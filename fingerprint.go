@@ -0,0 +1,127 @@
+package ipset
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// nodeHash is cached fingerprint of a subtree
+type nodeHash struct {
+	sum   [32]byte
+	valid bool
+}
+
+// emptyFingerprint is fingerprint of a set without prefixes
+var emptyFingerprint = sha256.Sum256(nil)
+
+// Fingerprint returns sha256 based hash of prefixes held by the set. The tree is canonical,
+// so it doesn't depend on insertion order. Leaves are hashed as 0x00, masked address and prefix length,
+// internal nodes as 0x01 and hashes of both children. Hashes of subtrees are cached under a lock
+// and dropped as mutations touch them, so the set can be fingerprinted while other readers use it.
+// Trees of other types have no cache, they are rebuilt and hashed in full on every call.
+func Fingerprint(t Tree) [32]byte {
+	s := treeOf(t)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.root == 0 {
+		return emptyFingerprint
+	}
+
+	return s.hash(s.root, 0)
+}
+
+// FingerprintWithin returns fingerprint of the part of the set within given prefix, it is equal
+// to fingerprint of a set holding only that part so sub-ranges of different sets can be compared.
+// Nil or invalid prefix is an error. Like Fingerprint, it rebuilds trees of other types.
+func FingerprintWithin(t Tree, within *net.IPNet) ([32]byte, error) {
+	node, err := leafFromNet(within)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("FingerprintWithin: %w", err)
+	}
+
+	s := treeOf(t)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	curr, offset := s.root, uint32(0)
+	for curr != 0 {
+		n := &s.nodes[curr]
+		matching := matchingPrefix(node.addr, n.addr)
+		parentOffset := offset
+		offset += n.prefix
+
+		// subtree is within the prefix
+		if matching >= node.prefix && node.prefix <= offset {
+			return s.hash(curr, parentOffset), nil
+		}
+
+		// subtree is disjoint with the prefix
+		if matching < node.prefix && matching < offset {
+			break
+		}
+
+		if n.left == 0 {
+			// stored prefix encloses the whole range
+			return leafHash(node.addr, node.prefix), nil
+		}
+
+		if (node.addr.Rsh(uint(128 - (offset + 1)))).And64(0x01) == uint128.Zero {
+			curr = n.left
+		} else {
+			curr = n.right
+		}
+	}
+
+	return emptyFingerprint, nil
+}
+
+// hash returns fingerprint of subtree of node i hanging at offset, computing missing ones, mu has to be held
+func (s *ipset) hash(i, offset uint32) [32]byte {
+	if len(s.hashes) < len(s.nodes) {
+		s.hashes = append(s.hashes, make([]nodeHash, len(s.nodes)-len(s.hashes))...)
+	}
+	if h := &s.hashes[i]; h.valid {
+		return h.sum
+	}
+
+	n := &s.nodes[i]
+	offset += n.prefix
+
+	var sum [32]byte
+	if n.left == 0 {
+		sum = leafHash(maskAddr(n.addr, offset), offset)
+	} else {
+		sum = nodeSum(s.hash(n.left, offset), s.hash(n.right, offset))
+	}
+
+	s.hashes[i] = nodeHash{sum: sum, valid: true}
+	return sum
+}
+
+// invalidate drops cached fingerprint of node i
+func (s *ipset) invalidate(i uint32) {
+	if int(i) < len(s.hashes) {
+		s.hashes[i].valid = false
+	}
+}
+
+// nodeSum returns fingerprint of internal node from fingerprints of its children
+func nodeSum(left, right [32]byte) [32]byte {
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(append(append(buf, 1), left[:]...), right[:]...)
+
+	return sha256.Sum256(buf)
+}
+
+func leafHash(addr uint128.Uint128, prefix uint32) [32]byte {
+	var buf [18]byte
+	binary.BigEndian.PutUint64(buf[1:9], addr.Hi)
+	binary.BigEndian.PutUint64(buf[9:17], addr.Lo)
+	buf[17] = byte(prefix)
+
+	return sha256.Sum256(buf[:])
+}
@@ -0,0 +1,133 @@
+package ipset
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	empty := NewTree()
+	if Fingerprint(empty) != emptyFingerprint {
+		t.Errorf("unexpected fingerprint of empty set")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	cidrs := append(denseIPv4Cidrs(rng, 500), randomIPv6Cidrs(rng, 100)...)
+	expected := Fingerprint(NewTree(cidrs...))
+	if expected == emptyFingerprint {
+		t.Fatalf("fingerprint of non empty set equals the empty one")
+	}
+
+	for i := 0; i < 5; i++ {
+		rng.Shuffle(len(cidrs), func(i, j int) { cidrs[i], cidrs[j] = cidrs[j], cidrs[i] })
+		if got := Fingerprint(NewTree(cidrs...)); got != expected {
+			t.Fatalf("fingerprint depends on insertion order")
+		}
+	}
+
+	var b Builder
+	for _, cidr := range cidrs {
+		b.Add(cidr)
+	}
+	if Fingerprint(b.Build()) != expected {
+		t.Errorf("fingerprint of built tree differs")
+	}
+	if Fingerprint(NewTreeParallel(cidrs, 4)) != expected {
+		t.Errorf("fingerprint of parallel built tree differs")
+	}
+
+	other := NewTree(cidrs...)
+	other.Add(parseCidrs("192.168.0.1/32")[0])
+	if Fingerprint(other) == expected {
+		t.Errorf("fingerprints of different sets are equal")
+	}
+}
+
+func TestFingerprintMutations(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	set := NewTree(denseIPv4Cidrs(rng, 200)...)
+	initial := Fingerprint(set)
+
+	for i, cidr := range denseIPv4Cidrs(rng, 500) {
+		if i%2 == 0 {
+			set.Add(cidr)
+		} else {
+			set.Remove(cidr)
+		}
+
+		if got, expected := Fingerprint(set), Fingerprint(NewTree(set.Prefixes()...)); got != expected {
+			t.Fatalf("stale fingerprint after %d mutations", i)
+		}
+	}
+
+	set = NewTree(parseCidrs("10.0.0.0/16")...)
+	before := Fingerprint(set)
+	set.Remove(parseCidrs("10.0.1.0/24")[0])
+	set.Add(parseCidrs("10.0.1.0/24")[0])
+	if Fingerprint(set) != before {
+		t.Errorf("fingerprint differs after restoring the set")
+	}
+	if before == initial {
+		t.Errorf("fingerprints of different sets are equal")
+	}
+}
+
+func TestFingerprintWithin(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	set := NewTree(denseIPv4Cidrs(rng, 300)...)
+
+	for i := 0; i < 200; i++ {
+		prefixLen := 16 + rng.Intn(17)
+		mask := net.CIDRMask(prefixLen, 32)
+		ip := net.IPv4(10, 0, byte(rng.Intn(16)), byte(rng.Intn(256))).To4()
+		within := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+
+		gaps, err := Gaps(set, within)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part := NewTree(within)
+		for _, gap := range gaps {
+			part.Remove(gap)
+		}
+
+		if got, err := FingerprintWithin(set, within); err != nil || got != Fingerprint(part) {
+			t.Fatalf("fingerprint within %s mismatch (set part: %v)", within, part.Prefixes())
+		}
+	}
+
+	if got, err := FingerprintWithin(set, parseCidrs("0.0.0.0/0")[0]); err != nil || got != Fingerprint(set) {
+		t.Errorf("fingerprint within whole space differs from the fingerprint (err: %v)", err)
+	}
+	if got, err := FingerprintWithin(set, parseCidrs("192.168.0.0/16")[0]); err != nil || got != emptyFingerprint {
+		t.Errorf("fingerprint within disjoint prefix differs from the empty one (err: %v)", err)
+	}
+	if _, err := FingerprintWithin(set, nil); err == nil {
+		t.Errorf("fingerprint within nil prefix was returned")
+	}
+}
+
+func TestFingerprintConcurrentReaders(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	set := NewTree(denseIPv4Cidrs(rng, 300)...)
+	expected := Fingerprint(NewTree(set.Prefixes()...))
+
+	// run with -race, fingerprinting fills the cache while others read the set
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				set.Contains(net.IPv4(10, 0, byte(j), byte(i)))
+				if Fingerprint(set) != expected {
+					t.Errorf("unexpected fingerprint")
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
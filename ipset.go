@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 
 	"lukechampine.com/uint128"
 )
@@ -15,13 +16,40 @@ type Set interface {
 	ContainsRawIPv4(uint32) bool
 }
 
+// Tree is a Set backed by the patricia tree, it can be modified after construction.
+// Sets returned by NewSet and NewSetFromCSV implement it as well. Functions taking Tree
+// work with other implementations too, using the tree built from their prefixes on every call,
+// so for them even the cheap ones take time linear in the size of the set.
+type Tree interface {
+	Set
+	Add(*net.IPNet)
+	Remove(*net.IPNet)
+	Prefixes() []*net.IPNet
+}
+
 // ipset is a set based on radix tree (r = 2, so called patricia tree)
+// nodes are kept in a single slice and reference each other by index so gc doesn't have to scan them
 type ipset struct {
-	root *treeNode
+	nodes []treeNode
+	// free holds indexes of released nodes, reused by next allocations
+	free []uint32
+	root uint32
+	// counts of subtrees are built on first use and kept up to date by mutations afterwards,
+	// so sets never asked for them don't pay for their memory
+	counts []nodeCount
+	// hashes caches fingerprints of subtrees, allocated on first use
+	hashes []nodeHash
+	// mu guards building of counts and fingerprint cache against concurrent readers
+	mu sync.Mutex
 }
 
 // NewSet constructs CIDRSet from list of cidrs
 func NewSet(cidrs ...*net.IPNet) Set {
+	return NewTree(cidrs...)
+}
+
+// NewTree constructs Tree from list of cidrs
+func NewTree(cidrs ...*net.IPNet) Tree {
 	s := &ipset{}
 
 	for _, cidr := range cidrs {
@@ -41,6 +69,23 @@ func NewSetFromCSV(cidrsCSV string) (Set, error) {
 	return NewSet(cidrs...), nil
 }
 
+// treeOf returns the patricia tree behind t, other implementations are rebuilt from their prefixes
+func treeOf(t Tree) *ipset {
+	if s, ok := t.(*ipset); ok {
+		return s
+	}
+	if t == nil {
+		return &ipset{}
+	}
+
+	var b Builder
+	for _, cidr := range t.Prefixes() {
+		b.Add(cidr)
+	}
+
+	return b.Build().(*ipset)
+}
+
 func uint128FromIP(ip net.IP) (uint128.Uint128, error) {
 	ipv6 := ip.To16()
 	if ipv6 == nil {
@@ -51,23 +96,24 @@ func uint128FromIP(ip net.IP) (uint128.Uint128, error) {
 }
 
 func (s *ipset) ContainsRawIPv4(ipRaw uint32) bool {
-	ipByte := make([]byte, 4)
-	binary.BigEndian.PutUint32(ipByte, ipRaw)
-	ip := net.IP(ipByte)
-	return s.Contains(ip)
+	return s.contains(ipv4Space.Or64(uint64(ipRaw)))
 }
 
 func (s *ipset) Contains(ip net.IP) bool {
-	if s.root == nil {
+	addr, err := uint128FromIP(ip)
+	if err != nil {
 		return false
 	}
 
-	addr, err := uint128FromIP(ip)
-	if err != nil {
+	return s.contains(addr)
+}
+
+func (s *ipset) contains(addr uint128.Uint128) bool {
+	if s.root == 0 {
 		return false
 	}
 
-	curr := s.root
+	curr := &s.nodes[s.root]
 	offset := uint32(0)
 	for {
 		matching := matchingPrefix(addr, curr.addr)
@@ -76,67 +122,96 @@ func (s *ipset) Contains(ip net.IP) bool {
 			return false
 		}
 
-		if curr.left == nil || matching == 128 {
+		if curr.left == 0 {
 			return true
 		}
 
 		if addr.Rsh(uint(128-(offset+1))).And64(0x01) == uint128.Zero {
-			curr = curr.left
+			curr = &s.nodes[curr.left]
 		} else {
-			curr = curr.right
+			curr = &s.nodes[curr.right]
 		}
 	}
 }
 
 func (s *ipset) Add(subnet *net.IPNet) {
-	node, err := nodeFromNet(subnet)
+	node, err := leafFromNet(subnet)
 	if err != nil {
 		panic(err)
 	}
 
-	if s.root == nil {
-		s.root = node
+	s.add(node)
+}
+
+// add inserts node into the tree, node can also be a root of subtree disjoint with all stored prefixes
+// in which case its prefix holds depth of the subtree root
+func (s *ipset) add(node treeNode) {
+	if s.root == 0 {
+		s.root = s.alloc(node)
 		return
 	}
 
+	// nodes visited on the way down with offsets of their parents, candidates for merging
+	// and recounting once new subnet is in place
+	var path, offsets [129]uint32
+	depth := 0
+
 	curr := s.root
 	offset := uint32(0)
 	for {
-		matching := matchingPrefix(node.addr, curr.addr)
-		offset += curr.prefix
+		path[depth], offsets[depth] = curr, offset
+		depth++
+
+		n := &s.nodes[curr]
+		matching := matchingPrefix(node.addr, n.addr)
+		offset += n.prefix
 
 		// incoming subnet has shorter prefix, discard remaining parts of the tree
-		if matching >= node.prefix && node.prefix < offset {
-			curr.prefix += node.prefix - offset
-			curr.left, curr.right = nil, nil
+		if matching >= node.prefix && node.prefix <= offset {
+			n.prefix += node.prefix - offset
+			n.addr = node.addr
+			left, right := n.left, n.right
+			n.left, n.right = 0, 0
+			s.release(left)
+			s.release(right)
+			s.merge(path[:depth])
+			s.recount(path[:depth], offsets[:depth])
 			return
 		}
 
 		if matching < node.prefix && matching < offset {
 			// three way split
-			newNode := &treeNode{
+			newNode := s.alloc(treeNode{
 				addr:   node.addr,
 				prefix: node.prefix - matching,
-			}
-			splittedNode := &treeNode{
-				addr:   curr.addr,
+				left:   node.left,
+				right:  node.right,
+			})
+			n = &s.nodes[curr]
+			splittedNode := s.alloc(treeNode{
+				addr:   n.addr,
 				prefix: offset - matching,
-				left:   curr.left,
-				right:  curr.right,
-			}
+				left:   n.left,
+				right:  n.right,
+			})
+			n = &s.nodes[curr]
 
-			if curr.addr.Rsh(uint(128-(matching+1))).And64(0x01) == uint128.Zero {
-				curr.left, curr.right = splittedNode, newNode
+			if n.addr.Rsh(uint(128-(matching+1))).And64(0x01) == uint128.Zero {
+				n.left, n.right = splittedNode, newNode
 			} else {
-				curr.left, curr.right = newNode, splittedNode
+				n.left, n.right = newNode, splittedNode
 			}
 
-			curr.prefix += matching - offset
+			n.prefix += matching - offset
+			s.updateCount(newNode, matching)
+			s.updateCount(splittedNode, matching)
+			s.merge(path[:depth])
+			s.recount(path[:depth], offsets[:depth])
 
 			return
 		}
 
-		if curr.left == nil {
+		if n.left == 0 {
 			// currently stored prefix is shorter than new one
 			// so new subnet is enclosed by existing subnet, nothing to do
 			return
@@ -144,28 +219,254 @@ func (s *ipset) Add(subnet *net.IPNet) {
 
 		// we are still traversing through prefix, decide which route next
 		if (node.addr.Rsh(uint(128 - (offset + 1)))).And64(0x01) == uint128.Zero {
-			curr = curr.left
+			curr = n.left
 		} else {
-			curr = curr.right
+			curr = n.right
+		}
+	}
+}
+
+// merge collapses nodes on the path, starting from its end, whose children are complementing leaves
+// so that two halves of a block are always stored as the block itself
+func (s *ipset) merge(path []uint32) {
+	for i := len(path) - 1; i >= 0; i-- {
+		n := &s.nodes[path[i]]
+		if n.left == 0 {
+			continue
+		}
+
+		left, right := s.nodes[n.left], s.nodes[n.right]
+		if left.left != 0 || right.left != 0 || left.prefix != 1 || right.prefix != 1 {
+			return
+		}
+
+		s.release(n.left)
+		s.release(n.right)
+		n.addr, n.left, n.right = left.addr, 0, 0
+	}
+}
+
+// Remove excludes subnet from the set, stored prefixes enclosing it are split into remaining parts
+func (s *ipset) Remove(subnet *net.IPNet) {
+	node, err := nodeFromNet(subnet)
+	if err != nil {
+		panic(err)
+	}
+
+	// ancestors of curr with offsets of their parents, recounted once subtree is unlinked
+	var path, offsets [129]uint32
+	depth := 0
+
+	parent, curr := uint32(0), s.root
+	offset := uint32(0)
+	for curr != 0 {
+		n := &s.nodes[curr]
+		matching := matchingPrefix(node.addr, n.addr)
+		parentOffset := offset
+		offset += n.prefix
+
+		// removed subnet covers whole subtree
+		if matching >= node.prefix && node.prefix <= offset {
+			s.unlink(parent, curr)
+			s.recount(path[:depth], offsets[:depth])
+			return
+		}
+
+		// removed subnet is disjoint with subtree
+		if matching < node.prefix && matching < offset {
+			return
+		}
+
+		if n.left == 0 {
+			// stored prefix encloses removed subnet, replace it with blocks surrounding the subnet
+			s.unlink(parent, curr)
+			s.recount(path[:depth], offsets[:depth])
+			for d := offset; d < node.prefix; d++ {
+				s.add(treeNode{
+					addr:   maskAddr(node.addr, d+1).Xor(uint128.From64(1).Lsh(uint(127 - d))),
+					prefix: d + 1,
+				})
+			}
+			return
+		}
+
+		parent = curr
+		path[depth], offsets[depth] = curr, parentOffset
+		depth++
+		if (node.addr.Rsh(uint(128 - (offset + 1)))).And64(0x01) == uint128.Zero {
+			curr = n.left
+		} else {
+			curr = n.right
+		}
+	}
+}
+
+// unlink removes curr subtree, its sibling takes place of the parent
+func (s *ipset) unlink(parent, curr uint32) {
+	if parent == 0 {
+		s.release(curr)
+		s.root = 0
+		return
+	}
+
+	p := &s.nodes[parent]
+	sibling := p.left
+	if sibling == curr {
+		sibling = p.right
+	}
+	sib := s.nodes[sibling]
+
+	p.addr, p.prefix, p.left, p.right = sib.addr, p.prefix+sib.prefix, sib.left, sib.right
+	s.nodes[sibling] = treeNode{}
+	s.free = append(s.free, sibling)
+	s.release(curr)
+}
+
+// alloc stores node in the slice reusing released slots, returned index is never 0
+func (s *ipset) alloc(node treeNode) uint32 {
+	if n := len(s.free); n > 0 {
+		i := s.free[n-1]
+		s.free = s.free[:n-1]
+		s.nodes[i] = node
+		s.invalidate(i)
+		return i
+	}
+
+	if len(s.nodes) == 0 {
+		// index 0 stands for no node
+		s.nodes = append(s.nodes, treeNode{})
+	}
+	s.nodes = append(s.nodes, node)
+
+	return uint32(len(s.nodes) - 1)
+}
+
+// release returns node with all its descendants to the free list
+func (s *ipset) release(i uint32) {
+	if i == 0 {
+		return
+	}
+
+	n := s.nodes[i]
+	s.release(n.left)
+	s.release(n.right)
+	s.nodes[i] = treeNode{}
+	s.free = append(s.free, i)
+}
+
+// recount updates cached counts of nodes on the path starting from its end, offsets are the ones of their parents
+func (s *ipset) recount(path, offsets []uint32) {
+	for i := len(path) - 1; i >= 0; i-- {
+		s.updateCount(path[i], offsets[i])
+	}
+}
+
+// updateCount sets counts of node i hanging at offset from counts of its children once counts
+// are built, it is called for every node whose subtree changes so it also drops cached fingerprint of the node
+func (s *ipset) updateCount(i, offset uint32) {
+	s.invalidate(i)
+	if s.counts == nil {
+		return
+	}
+
+	if len(s.counts) < len(s.nodes) {
+		s.counts = append(s.counts, make([]nodeCount, len(s.nodes)-len(s.counts))...)
+	}
+	n := &s.nodes[i]
+	if n.left == 0 {
+		s.counts[i] = nodeCount{}
+		return
+	}
+
+	offset += n.prefix
+	s.counts[i] = nodeCount{
+		addrs:  s.size(n.left, offset).Add(s.size(n.right, offset)),
+		leaves: s.leafCount(n.left) + s.leafCount(n.right),
+	}
+}
+
+// buildCounts counts addresses and prefixes of all subtrees unless it was done before,
+// it has to be called before size and leafCount
+func (s *ipset) buildCounts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts != nil {
+		return
+	}
+
+	counts := make([]nodeCount, len(s.nodes))
+	var visit func(i, offset uint32) nodeCount
+	visit = func(i, offset uint32) nodeCount {
+		n := &s.nodes[i]
+		offset += n.prefix
+		if n.left == 0 {
+			return nodeCount{addrs: hostMask(offset).AddWrap64(1), leaves: 1}
 		}
+
+		left, right := visit(n.left, offset), visit(n.right, offset)
+		counts[i] = nodeCount{addrs: left.addrs.Add(right.addrs), leaves: left.leaves + right.leaves}
+		return counts[i]
+	}
+
+	if s.root != 0 {
+		visit(s.root, 0)
+	}
+	s.counts = counts
+}
+
+// leafCount returns number of prefixes stored in subtree of node i
+func (s *ipset) leafCount(i uint32) uint32 {
+	if n := &s.nodes[i]; n.left != 0 {
+		return s.counts[i].leaves
 	}
+
+	return 1
+}
+
+// size returns number of addresses covered by subtree of node i hanging at offset,
+// it overflows for a leaf covering whole key space
+func (s *ipset) size(i, offset uint32) uint128.Uint128 {
+	n := &s.nodes[i]
+	if n.left != 0 {
+		return s.counts[i].addrs
+	}
+
+	return hostMask(offset + n.prefix).AddWrap64(1)
 }
 
 type treeNode struct {
 	addr   uint128.Uint128
 	prefix uint32
-	left   *treeNode
-	right  *treeNode
+	// left and right are indexes of children in ipset.nodes, 0 if there are none
+	left  uint32
+	right uint32
+}
+
+// nodeCount holds numbers of addresses and prefixes in subtree of internal node,
+// leaves have them implied by prefix
+type nodeCount struct {
+	addrs  uint128.Uint128
+	leaves uint32
 }
 
 func nodeFromNet(cidr *net.IPNet) (*treeNode, error) {
+	node, err := leafFromNet(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+// leafFromNet is nodeFromNet returning node by value, so that it doesn't escape to heap
+func leafFromNet(cidr *net.IPNet) (treeNode, error) {
 	if cidr == nil {
-		return nil, fmt.Errorf("nil node passed")
+		return treeNode{}, fmt.Errorf("nil node passed")
 	}
 
 	addr, err := uint128FromIP(cidr.IP)
 	if err != nil {
-		return nil, err
+		return treeNode{}, err
 	}
 
 	prefixLen, size := cidr.Mask.Size()
@@ -176,7 +477,7 @@ func nodeFromNet(cidr *net.IPNet) (*treeNode, error) {
 		prefixLen += 96
 	}
 
-	return &treeNode{addr: addr, prefix: uint32(prefixLen)}, nil
+	return treeNode{addr: maskAddr(addr, uint32(prefixLen)), prefix: uint32(prefixLen)}, nil
 }
 
 func (n *treeNode) String() string {
@@ -191,6 +492,65 @@ func (n *treeNode) Equals(node *treeNode) bool {
 	return n.addr.Equals(node.addr) && n.prefix == node.prefix
 }
 
+// Prefixes returns minimal list of prefixes covering the set in ascending order, ipv4 ones are returned as ipv4 networks
+func (s *ipset) Prefixes() []*net.IPNet {
+	var prefixes []*net.IPNet
+	s.walk(func(addr uint128.Uint128, prefix uint32) {
+		prefixes = append(prefixes, netFromAddr(addr, prefix))
+	})
+
+	return prefixes
+}
+
+// PrefixesByFamily returns minimal lists of ipv4 and ipv6 prefixes of the set, for consumers keeping
+// families apart. Ipv6 prefixes enclosing ::ffff:0:0/96 cover the whole ipv4 space, which is added
+// to ipv4 prefixes as 0.0.0.0/0. Sets unable to list their prefixes are an error.
+func PrefixesByFamily(s Set) (ipv4, ipv6 []*net.IPNet, err error) {
+	l, ok := s.(interface{ Prefixes() []*net.IPNet })
+	if !ok {
+		return nil, nil, fmt.Errorf("PrefixesByFamily: unsupported set type %T", s)
+	}
+
+	for _, p := range l.Prefixes() {
+		if len(p.IP) == net.IPv4len {
+			ipv4 = append(ipv4, p)
+			continue
+		}
+
+		ipv6 = append(ipv6, p)
+		if node, err := leafFromNet(p); err == nil && node.prefix <= 96 && matchingPrefix(node.addr, ipv4Space) >= node.prefix {
+			ipv4 = append(ipv4, &net.IPNet{IP: make(net.IP, net.IPv4len), Mask: net.CIDRMask(0, 32)})
+		}
+	}
+
+	return ipv4, ipv6, nil
+}
+
+// walk calls fn for every prefix stored in the tree in ascending order, addr is masked to prefix
+func (s *ipset) walk(fn func(addr uint128.Uint128, prefix uint32)) {
+	var visit func(i, offset uint32)
+	visit = func(i, offset uint32) {
+		n := &s.nodes[i]
+		offset += n.prefix
+		if n.left == 0 {
+			fn(maskAddr(n.addr, offset), offset)
+			return
+		}
+
+		visit(n.left, offset)
+		visit(n.right, offset)
+	}
+
+	if s.root != 0 {
+		visit(s.root, 0)
+	}
+}
+
+// maskAddr zeroes all bits of addr past prefix
+func maskAddr(addr uint128.Uint128, prefix uint32) uint128.Uint128 {
+	return addr.And(uint128.Max.Lsh(uint(128 - prefix)))
+}
+
 func matchingPrefix(l, r uint128.Uint128) uint32 {
 	return uint32(l.Xor(r).LeadingZeros())
 }
@@ -2,7 +2,10 @@ package ipset
 
 import (
 	"encoding/binary"
+	"fmt"
+	"math/rand"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -86,6 +89,11 @@ var groups = []struct {
 		cidrs:       parseCidrs("255.255.255.0/24", "255.255.240.0/20"),
 		negativeIPs: []net.IP{net.ParseIP("254.0.0.2")},
 	},
+	{
+		name:        "block equal to branching point",
+		cidrs:       parseCidrs("10.0.0.0/24", "10.0.1.0/25", "10.0.0.0/23"),
+		negativeIPs: []net.IP{net.ParseIP("10.0.2.0")},
+	},
 	{
 		name:  "two disjoint ipv4 blocks",
 		cidrs: parseCidrs("192.168.0.0/24", "255.0.0.0/20"),
@@ -173,6 +181,118 @@ func TestSetContainsIPv6(t *testing.T) {
 	}
 }
 
+func TestSetRemove(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		cidrs       []*net.IPNet
+		removed     []*net.IPNet
+		positiveIPs []net.IP
+		negativeIPs []net.IP
+	}{
+		{
+			desc:        "remove whole block",
+			cidrs:       parseCidrs("10.0.0.0/8", "192.168.0.0/16"),
+			removed:     parseCidrs("10.0.0.0/8"),
+			positiveIPs: []net.IP{net.ParseIP("192.168.1.1")},
+			negativeIPs: []net.IP{net.ParseIP("10.0.0.1")},
+		},
+		{
+			desc:        "remove subnet of stored block",
+			cidrs:       parseCidrs("10.0.0.0/8"),
+			removed:     parseCidrs("10.1.2.3/32"),
+			positiveIPs: []net.IP{net.ParseIP("10.0.0.0"), net.ParseIP("10.1.2.2"), net.ParseIP("10.1.2.4"), net.ParseIP("10.255.255.255")},
+			negativeIPs: []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("11.0.0.0")},
+		},
+		{
+			desc:        "remove block enclosing several stored",
+			cidrs:       parseCidrs("10.0.0.0/24", "10.0.1.0/24", "10.1.0.0/16"),
+			removed:     parseCidrs("10.0.0.0/16"),
+			positiveIPs: []net.IP{net.ParseIP("10.1.0.0")},
+			negativeIPs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.1.1")},
+		},
+		{
+			desc:        "remove disjoint block",
+			cidrs:       parseCidrs("10.0.0.0/24"),
+			removed:     parseCidrs("10.0.1.0/24", "::/0"),
+			negativeIPs: []net.IP{net.ParseIP("10.0.0.1")},
+		},
+		{
+			desc:        "remove everything",
+			cidrs:       parseCidrs("10.0.0.0/24", "2001:db8::/32"),
+			removed:     parseCidrs("::/0"),
+			negativeIPs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := NewTree(tc.cidrs...)
+			for _, cidr := range tc.removed {
+				s.Remove(cidr)
+			}
+
+			for _, ip := range tc.positiveIPs {
+				if !s.Contains(ip) {
+					t.Errorf("positive case returned false: %s", ip)
+				}
+			}
+			for _, ip := range tc.negativeIPs {
+				if s.Contains(ip) {
+					t.Errorf("negative case returned true: %s", ip)
+				}
+			}
+		})
+	}
+}
+
+func TestSetAddRemoveRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewTree()
+	// reference model of 10.0.0.0/20
+	var model [4096]bool
+
+	for op := 0; op < 300; op++ {
+		prefixLen := 20 + rng.Intn(13)
+		first := uint32(rng.Intn(4096)) &^ (1<<(32-prefixLen) - 1)
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, 0x0a000000|first)
+		cidr := &net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLen, 32)}
+
+		add := rng.Intn(2) == 0
+		if add {
+			s.Add(cidr)
+		} else {
+			s.Remove(cidr)
+		}
+		for i := first; i < first+1<<(32-prefixLen); i++ {
+			model[i] = add
+		}
+
+		for i, expected := range model {
+			if got := s.ContainsRawIPv4(0x0a000000 | uint32(i)); got != expected {
+				t.Fatalf("mismatch after %d ops at %d (expected: %t, got: %t)", op, i, expected, got)
+			}
+		}
+	}
+}
+
+func TestSetReusesReleasedNodes(t *testing.T) {
+	cidrs := randomIPv4Cidrs(rand.New(rand.NewSource(1)), 1000)
+	s := NewTree(cidrs...).(*ipset)
+	size := len(s.nodes)
+
+	s.Remove(parseCidrs("0.0.0.0/0")[0])
+	if s.root != 0 || len(s.free) != size-1 {
+		t.Fatalf("expected all nodes to be released (root: %d, free: %d)", s.root, len(s.free))
+	}
+
+	for _, cidr := range cidrs {
+		s.Add(cidr)
+	}
+	if len(s.nodes) != size {
+		t.Errorf("nodes were not reused (expected: %d, got: %d)", size, len(s.nodes))
+	}
+}
+
 func TestNodeFromSet(t *testing.T) {
 	parseCidr := func(foo string) *net.IPNet {
 		_, subnet, err := net.ParseCIDR(foo)
@@ -352,3 +472,163 @@ func inetAtoN(ipnr net.IP) uint32 {
 
 	return sum
 }
+
+func randomIPv6Cidrs(rng *rand.Rand, n int) []*net.IPNet {
+	cidrs := make([]*net.IPNet, n)
+	for i := range cidrs {
+		ip := make(net.IP, 16)
+		binary.BigEndian.PutUint64(ip, 0x2000000000000000|rng.Uint64()>>3)
+		binary.BigEndian.PutUint64(ip[8:], rng.Uint64())
+		mask := net.CIDRMask(16+rng.Intn(49), 128)
+		cidrs[i] = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+
+	return cidrs
+}
+
+func BenchmarkNewSet(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	cidrs := append(randomIPv4Cidrs(rng, 500000), randomIPv6Cidrs(rng, 500000)...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewSet(cidrs...)
+	}
+}
+
+func BenchmarkSetContains(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewSet(append(randomIPv4Cidrs(rng, 500000), randomIPv6Cidrs(rng, 500000)...)...)
+	ips := make([]net.IP, 1<<16)
+	for i := range ips {
+		if i&1 == 0 {
+			ips[i] = make(net.IP, 4)
+			binary.BigEndian.PutUint32(ips[i], rng.Uint32())
+		} else {
+			ips[i] = randomIPv6Cidrs(rng, 1)[0].IP
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Contains(ips[i&0xffff])
+	}
+}
+
+func BenchmarkGC(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewSet(append(randomIPv4Cidrs(rng, 500000), randomIPv6Cidrs(rng, 500000)...)...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	runtime.KeepAlive(s)
+}
+
+func TestSetPrefixes(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		cidrs    []*net.IPNet
+		expected []string
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc:     "covered and complementing blocks",
+			cidrs:    parseCidrs("10.0.1.0/24", "10.0.0.0/24", "10.0.0.128/25", "192.168.0.0/16", "2001:db8::/32"),
+			expected: []string{"10.0.0.0/23", "192.168.0.0/16", "2001:db8::/32"},
+		},
+		{
+			desc:     "ipv6 prefix enclosing ipv4 space",
+			cidrs:    parseCidrs("10.0.0.0/8", "::/64"),
+			expected: []string{"::/64"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			prefixes := NewTree(tc.cidrs...).Prefixes()
+			if len(prefixes) != len(tc.expected) {
+				t.Fatalf("mismatch (expected: %v, got: %v)", tc.expected, prefixes)
+			}
+
+			for i, prefix := range prefixes {
+				if prefix.String() != tc.expected[i] {
+					t.Errorf("mismatch (expected: %v, got: %v)", tc.expected, prefixes)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefixesByFamily(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		cidrs []*net.IPNet
+		ipv4  string
+		ipv6  string
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc:  "both families",
+			cidrs: parseCidrs("10.0.0.0/8", "192.168.0.0/24", "2001:db8::/32", "::/120"),
+			ipv4:  "10.0.0.0/8 192.168.0.0/24",
+			ipv6:  "::/120 2001:db8::/32",
+		},
+		{
+			desc:  "ipv6 enclosing ipv4 space",
+			cidrs: parseCidrs("10.0.0.0/8", "::/64", "2001:db8::/32"),
+			ipv4:  "0.0.0.0/0",
+			ipv6:  "::/64 2001:db8::/32",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ipv4, ipv6, err := PrefixesByFamily(NewSet(tc.cidrs...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(ipv4); got != "["+tc.ipv4+"]" {
+				t.Errorf("ipv4 mismatch (expected: %q, got: %q)", tc.ipv4, got)
+			}
+			if got := fmt.Sprint(ipv6); got != "["+tc.ipv6+"]" {
+				t.Errorf("ipv6 mismatch (expected: %q, got: %q)", tc.ipv6, got)
+			}
+		})
+	}
+
+	// wrapper hides Prefixes of the tree
+	if _, _, err := PrefixesByFamily(struct{ Set }{NewSet()}); err == nil {
+		t.Errorf("set unable to list prefixes was accepted")
+	}
+}
+
+func TestOtherTreeImplementation(t *testing.T) {
+	// wrapper hides the patricia tree behind its exported methods
+	other := struct{ Tree }{NewTree(parseCidrs("10.0.0.0/24", "10.0.2.0/24")...)}
+
+	if rank := Rank(other, net.ParseIP("10.0.2.1")); !rank.Equals64(257) {
+		t.Errorf("unexpected rank %s", rank)
+	}
+	complement, err := ComplementWithin(other, parseCidrs("10.0.0.0/22")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prefixStrings(complement); got != "10.0.1.0/24 10.0.3.0/24" {
+		t.Errorf("unexpected complement %s", got)
+	}
+
+	// allocation goes through Add of the tree
+	block, err := Allocate(other, parseCidrs("10.0.0.0/22")[0], 24)
+	if err != nil || block.String() != "10.0.1.0/24" || !other.Contains(net.ParseIP("10.0.1.1")) {
+		t.Errorf("unexpected allocation %v (err: %v)", block, err)
+	}
+
+	if err := Validate(other); err == nil {
+		t.Errorf("tree of other type was validated")
+	}
+}
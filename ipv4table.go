@@ -0,0 +1,102 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// ipv4Space is ::ffff:0:0/96, the part of the key space holding ipv4 mapped addrs
+var ipv4Space = uint128.New(0xffff00000000, 0)
+
+// IPv4Table is DIR-24-8 lookup table compiled from a Set, it answers ipv4 queries with at most two memory reads.
+// First level is indexed by top 24 bits of the address, prefixes longer than /24 are kept in 256 bit overflow blocks.
+// Table takes 64MiB for the first level plus 32 bytes per /24 containing longer prefixes, ipv6 addrs are never contained.
+type IPv4Table struct {
+	// tbl24 entry is 0 when /24 is not covered, 1 when it is fully covered, otherwise it is overflow block index + 2
+	tbl24 []uint32
+	// tbl8 holds overflow blocks as bitmaps, 4 words per block
+	tbl8 []uint64
+}
+
+// prefixWalker is implemented by sets able to list their prefixes
+type prefixWalker interface {
+	walk(fn func(addr uint128.Uint128, prefix uint32))
+}
+
+// NewIPv4Table compiles ipv4 part of the set into IPv4Table
+func NewIPv4Table(s Set) (*IPv4Table, error) {
+	w, ok := s.(prefixWalker)
+	if !ok {
+		return nil, fmt.Errorf("NewIPv4Table: unsupported set type %T", s)
+	}
+
+	t := &IPv4Table{tbl24: make([]uint32, 1<<24)}
+	w.walk(t.add)
+
+	return t, nil
+}
+
+func (t *IPv4Table) add(addr uint128.Uint128, prefix uint32) {
+	if prefix < 96 {
+		// prefix covers whole ipv4 space or none of it
+		if matchingPrefix(addr, ipv4Space) >= prefix {
+			for i := range t.tbl24 {
+				t.tbl24[i] = 1
+			}
+		}
+		return
+	}
+
+	if matchingPrefix(addr, ipv4Space) < 96 {
+		return
+	}
+
+	ip, prefixLen := uint32(addr.Lo), prefix-96
+	if prefixLen <= 24 {
+		first := ip >> 8
+		for i := first; i < first+1<<(24-prefixLen); i++ {
+			t.tbl24[i] = 1
+		}
+		return
+	}
+
+	entry := t.tbl24[ip>>8]
+	if entry == 0 {
+		entry = uint32(len(t.tbl8)/4) + 2
+		t.tbl24[ip>>8] = entry
+		t.tbl8 = append(t.tbl8, 0, 0, 0, 0)
+	}
+
+	block := t.tbl8[(entry-2)*4 : (entry-1)*4]
+	first := ip & 0xff
+	for i := first; i < first+1<<(32-prefixLen); i++ {
+		block[i>>6] |= 1 << (i & 63)
+	}
+}
+
+// Contains tells whether ipv4 address is covered by the table
+func (t *IPv4Table) Contains(ip net.IP) bool {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return false
+	}
+
+	return t.ContainsRawIPv4(uint32(ipv4[0])<<24 | uint32(ipv4[1])<<16 | uint32(ipv4[2])<<8 | uint32(ipv4[3]))
+}
+
+// ContainsRawIPv4 tells whether ipv4 address in host order is covered by the table
+func (t *IPv4Table) ContainsRawIPv4(ipRaw uint32) bool {
+	entry := t.tbl24[ipRaw>>8]
+	if entry < 2 {
+		return entry == 1
+	}
+
+	return t.tbl8[(entry-2)*4+(ipRaw&0xff)>>6]>>(ipRaw&63)&0x01 == 1
+}
+
+// Size returns number of bytes used by the table
+func (t *IPv4Table) Size() int {
+	return len(t.tbl24)*4 + len(t.tbl8)*8
+}
@@ -0,0 +1,168 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestIPv4TableContains(t *testing.T) {
+	for _, group := range groups {
+		t.Run(group.name, func(t *testing.T) {
+			s := NewSet(group.cidrs...)
+			table, err := NewIPv4Table(s)
+			if err != nil {
+				t.Fatalf("NewIPv4Table failed: %v", err)
+			}
+
+			for _, ip := range group.negativeIPs {
+				if table.Contains(ip) != s.Contains(ip) {
+					t.Errorf("mismatch for %s", ip)
+				}
+			}
+
+			for _, cidr := range group.cidrs {
+				low, high, err := getHostsRangeFromCIDR(cidr.String())
+				if err != nil {
+					t.Fatalf("Getting cidr range failed: %v", err)
+				}
+
+				for i := uint64(low); i <= uint64(high); i++ {
+					if !table.ContainsRawIPv4(uint32(i)) {
+						t.Fatalf("positive case returned false: %d", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestIPv4TableRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cidrs := randomIPv4Cidrs(rng, 2000)
+	s := NewSet(cidrs...)
+	table, err := NewIPv4Table(s)
+	if err != nil {
+		t.Fatalf("NewIPv4Table failed: %v", err)
+	}
+
+	ip := make(net.IP, 4)
+	for i := 0; i < 100000; i++ {
+		raw := rng.Uint32()
+		binary.BigEndian.PutUint32(ip, raw)
+		if got, expected := table.ContainsRawIPv4(raw), s.Contains(ip); got != expected {
+			t.Fatalf("mismatch for %s (expected: %t, got: %t)", ip, expected, got)
+		}
+	}
+}
+
+func TestIPv4TableSpecialCases(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		cidrs    []*net.IPNet
+		ip       net.IP
+		expected bool
+	}{
+		{
+			desc:     "ipv6 prefix covering ipv4 space",
+			cidrs:    parseCidrs("::/64"),
+			ip:       net.ParseIP("10.0.0.1"),
+			expected: true,
+		},
+		{
+			desc:     "ipv6 prefix not covering ipv4 space",
+			cidrs:    parseCidrs("2001:db8::/32"),
+			ip:       net.ParseIP("10.0.0.1"),
+			expected: false,
+		},
+		{
+			desc:     "ipv6 address",
+			cidrs:    parseCidrs("2001:db8::/32"),
+			ip:       net.ParseIP("2001:db8::1"),
+			expected: false,
+		},
+		{
+			desc:     "whole ipv4 space",
+			cidrs:    parseCidrs("0.0.0.0/0"),
+			ip:       net.ParseIP("255.255.255.255"),
+			expected: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			table, err := NewIPv4Table(NewSet(tc.cidrs...))
+			if err != nil {
+				t.Fatalf("NewIPv4Table failed: %v", err)
+			}
+
+			if got := table.Contains(tc.ip); got != tc.expected {
+				t.Errorf("mismatch (expected: %t, got: %t)", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestIPv4TableUnsupportedSet(t *testing.T) {
+	table, _ := NewIPv4Table(NewSet())
+	if _, err := NewIPv4Table(table); err == nil {
+		t.Errorf("expected error for unsupported set")
+	}
+}
+
+func randomIPv4Cidrs(rng *rand.Rand, n int) []*net.IPNet {
+	cidrs := make([]*net.IPNet, n)
+	for i := range cidrs {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, rng.Uint32())
+		mask := net.CIDRMask(8+rng.Intn(25), 32)
+		cidrs[i] = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+
+	return cidrs
+}
+
+func benchmarkIPs(n int) []uint32 {
+	rng := rand.New(rand.NewSource(2))
+	ips := make([]uint32, n)
+	for i := range ips {
+		ips[i] = rng.Uint32()
+	}
+
+	return ips
+}
+
+func BenchmarkSetContainsRawIPv4(b *testing.B) {
+	s := NewSet(randomIPv4Cidrs(rand.New(rand.NewSource(1)), 100000)...)
+	ips := benchmarkIPs(1 << 16)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ContainsRawIPv4(ips[i&0xffff])
+	}
+}
+
+func BenchmarkIPv4TableContainsRawIPv4(b *testing.B) {
+	table, err := NewIPv4Table(NewSet(randomIPv4Cidrs(rand.New(rand.NewSource(1)), 100000)...))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ips := benchmarkIPs(1 << 16)
+
+	b.ReportMetric(float64(table.Size()), "table-bytes")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.ContainsRawIPv4(ips[i&0xffff])
+	}
+}
+
+func BenchmarkNewIPv4Table(b *testing.B) {
+	s := NewSet(randomIPv4Cidrs(rand.New(rand.NewSource(1)), 100000)...)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewIPv4Table(s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package ipset
+
+import (
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// Map associates values with prefixes and answers longest prefix match queries.
+// Unlike Set it keeps prefixes nested in other ones, nodes of its tree may have a single child.
+type Map struct {
+	tree ipset
+	// values are indexed the same way as tree nodes
+	values []mapValue
+	len    int
+}
+
+type mapValue struct {
+	value  interface{}
+	stored bool
+}
+
+// NewMap constructs empty Map
+func NewMap() *Map {
+	return &Map{}
+}
+
+// Len returns number of prefixes stored
+func (m *Map) Len() int {
+	return m.len
+}
+
+// Insert associates value with cidr, replacing the one stored before
+func (m *Map) Insert(cidr *net.IPNet, value interface{}) {
+	node, err := leafFromNet(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	m.insert(node, value)
+}
+
+func (m *Map) insert(node treeNode, value interface{}) {
+	parent, curr := uint32(0), m.tree.root
+	offset := uint32(0)
+	for {
+		if curr == 0 {
+			leaf := m.alloc(treeNode{addr: node.addr, prefix: node.prefix - offset})
+			m.store(leaf, value)
+			m.link(parent, offset, leaf)
+			return
+		}
+
+		n := m.tree.nodes[curr]
+		matching := matchingPrefix(node.addr, n.addr)
+		nodeOffset := offset + n.prefix
+
+		if matching >= nodeOffset {
+			if node.prefix == nodeOffset {
+				m.store(curr, value)
+				return
+			}
+
+			if node.prefix > nodeOffset {
+				parent, curr, offset = curr, m.child(&n, node.addr, nodeOffset), nodeOffset
+				continue
+			}
+		}
+
+		// new prefix diverges from curr within its edge, either enclosing it or branching off
+		var split uint32
+		if matching >= node.prefix {
+			split = m.alloc(treeNode{addr: node.addr, prefix: node.prefix - offset})
+			m.store(split, value)
+			m.tree.nodes[curr].prefix = nodeOffset - node.prefix
+			m.link(split, node.prefix, curr)
+		} else {
+			leaf := m.alloc(treeNode{addr: node.addr, prefix: node.prefix - matching})
+			m.store(leaf, value)
+			split = m.alloc(treeNode{addr: maskAddr(node.addr, matching), prefix: matching - offset})
+			m.tree.nodes[curr].prefix = nodeOffset - matching
+			m.link(split, matching, curr)
+			m.link(split, matching, leaf)
+		}
+		m.replaceChild(parent, curr, split)
+		return
+	}
+}
+
+// Get returns value stored for exactly the same cidr
+func (m *Map) Get(cidr *net.IPNet) (interface{}, bool) {
+	node, err := leafFromNet(cidr)
+	if err != nil {
+		return nil, false
+	}
+
+	curr, offset := m.tree.root, uint32(0)
+	for curr != 0 {
+		n := &m.tree.nodes[curr]
+		offset += n.prefix
+		if offset > node.prefix || matchingPrefix(node.addr, n.addr) < offset {
+			return nil, false
+		}
+		if offset == node.prefix {
+			return m.values[curr].value, m.values[curr].stored
+		}
+
+		curr = m.child(n, node.addr, offset)
+	}
+
+	return nil, false
+}
+
+// Lookup returns value of the longest prefix containing ip together with the prefix
+func (m *Map) Lookup(ip net.IP) (interface{}, *net.IPNet, bool) {
+	addr, err := uint128FromIP(ip)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	var found, foundOffset uint32
+	curr, offset := m.tree.root, uint32(0)
+	for curr != 0 {
+		n := &m.tree.nodes[curr]
+		offset += n.prefix
+		if matchingPrefix(addr, n.addr) < offset {
+			break
+		}
+		if m.values[curr].stored {
+			found, foundOffset = curr, offset
+		}
+		if offset == 128 {
+			break
+		}
+
+		curr = m.child(n, addr, offset)
+	}
+
+	if found == 0 {
+		return nil, nil, false
+	}
+
+	return m.values[found].value, netFromAddr(m.tree.nodes[found].addr, foundOffset), true
+}
+
+// Match is a stored prefix together with its value
+type Match struct {
+	Prefix *net.IPNet
+	Value  interface{}
+}
+
+// AllMatches returns all stored prefixes containing ip with their values, from the shortest to the longest one
+func (m *Map) AllMatches(ip net.IP) []Match {
+	addr, err := uint128FromIP(ip)
+	if err != nil {
+		return nil
+	}
+
+	var matches []Match
+	curr, offset := m.tree.root, uint32(0)
+	for curr != 0 {
+		n := &m.tree.nodes[curr]
+		offset += n.prefix
+		if matchingPrefix(addr, n.addr) < offset {
+			break
+		}
+		if v := m.values[curr]; v.stored {
+			matches = append(matches, Match{Prefix: netFromAddr(n.addr, offset), Value: v.value})
+		}
+		if offset == 128 {
+			break
+		}
+
+		curr = m.child(n, addr, offset)
+	}
+
+	return matches
+}
+
+// Walk calls fn for every stored prefix, enclosing prefixes go before enclosed ones, walk stops when fn returns false
+func (m *Map) Walk(fn func(cidr *net.IPNet, value interface{}) bool) {
+	var visit func(i, offset uint32) bool
+	visit = func(i, offset uint32) bool {
+		if i == 0 {
+			return true
+		}
+
+		n := m.tree.nodes[i]
+		offset += n.prefix
+		if v := m.values[i]; v.stored && !fn(netFromAddr(n.addr, offset), v.value) {
+			return false
+		}
+
+		return visit(n.left, offset) && visit(n.right, offset)
+	}
+
+	visit(m.tree.root, 0)
+}
+
+// child returns index of child of n holding addr, n ends at offset
+func (m *Map) child(n *treeNode, addr uint128.Uint128, offset uint32) uint32 {
+	if addr.Rsh(uint(128-(offset+1))).And64(0x01) == uint128.Zero {
+		return n.left
+	}
+
+	return n.right
+}
+
+// link attaches child to parent ending at offset, 0 parent stands for the root
+func (m *Map) link(parent, offset, child uint32) {
+	if parent == 0 {
+		m.tree.root = child
+		return
+	}
+
+	p := &m.tree.nodes[parent]
+	if m.tree.nodes[child].addr.Rsh(uint(128-(offset+1))).And64(0x01) == uint128.Zero {
+		p.left = child
+	} else {
+		p.right = child
+	}
+}
+
+// replaceChild puts node in place of old child of parent
+func (m *Map) replaceChild(parent, old, node uint32) {
+	if parent == 0 {
+		m.tree.root = node
+		return
+	}
+
+	p := &m.tree.nodes[parent]
+	if p.left == old {
+		p.left = node
+	} else {
+		p.right = node
+	}
+}
+
+func (m *Map) alloc(node treeNode) uint32 {
+	i := m.tree.alloc(node)
+	for len(m.values) < len(m.tree.nodes) {
+		m.values = append(m.values, mapValue{})
+	}
+	m.values[i] = mapValue{}
+
+	return i
+}
+
+func (m *Map) store(i uint32, value interface{}) {
+	if !m.values[i].stored {
+		m.len++
+	}
+	m.values[i] = mapValue{value: value, stored: true}
+}
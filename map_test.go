@@ -0,0 +1,159 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMapLookup(t *testing.T) {
+	m := NewMap()
+	for i, cidr := range parseCidrs("10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "0.0.0.0/0", "2001:db8::/32", "2001:db8::/48", "192.168.0.0/24") {
+		m.Insert(cidr, i)
+	}
+	m.Insert(parseCidrs("10.1.0.0/16")[0], "site")
+
+	testCases := []struct {
+		ip     string
+		value  interface{}
+		prefix string
+	}{
+		{ip: "10.1.2.3", value: 2, prefix: "10.1.2.0/24"},
+		{ip: "10.1.3.3", value: "site", prefix: "10.1.0.0/16"},
+		{ip: "10.3.0.1", value: 0, prefix: "10.0.0.0/8"},
+		{ip: "10.2.0.1", value: 3, prefix: "10.2.0.0/16"},
+		{ip: "11.0.0.1", value: 4, prefix: "0.0.0.0/0"},
+		{ip: "192.168.0.255", value: 7, prefix: "192.168.0.0/24"},
+		{ip: "2001:db8::1", value: 6, prefix: "2001:db8::/48"},
+		{ip: "2001:db8:1::1", value: 5, prefix: "2001:db8::/32"},
+		{ip: "2001:db9::1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			value, prefix, ok := m.Lookup(net.ParseIP(tc.ip))
+			if ok != (tc.value != nil) {
+				t.Fatalf("unexpected result (value: %v, ok: %t)", value, ok)
+			}
+			if !ok {
+				return
+			}
+
+			if value != tc.value || prefix.String() != tc.prefix {
+				t.Errorf("mismatch (expected: %v %s, got: %v %s)", tc.value, tc.prefix, value, prefix)
+			}
+		})
+	}
+
+	if m.Len() != 8 {
+		t.Errorf("unexpected length %d", m.Len())
+	}
+}
+
+func TestMapGetAndWalk(t *testing.T) {
+	cidrs := parseCidrs("10.1.2.0/24", "10.0.0.0/8", "10.1.0.0/16", "::/0", "10.0.0.0/7")
+	m := NewMap()
+	for i, cidr := range cidrs {
+		m.Insert(cidr, i)
+	}
+
+	for i, cidr := range cidrs {
+		if value, ok := m.Get(cidr); !ok || value != i {
+			t.Errorf("unexpected value for %s (value: %v, ok: %t)", cidr, value, ok)
+		}
+	}
+	for _, cidr := range parseCidrs("10.1.2.0/25", "10.0.0.0/9", "0.0.0.0/0") {
+		if value, ok := m.Get(cidr); ok {
+			t.Errorf("unexpected value for %s: %v", cidr, value)
+		}
+	}
+
+	var walked []string
+	m.Walk(func(cidr *net.IPNet, value interface{}) bool {
+		walked = append(walked, cidr.String())
+		return true
+	})
+	expected := []string{"::/0", "10.0.0.0/7", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24"}
+	if len(walked) != len(expected) {
+		t.Fatalf("mismatch (expected: %v, got: %v)", expected, walked)
+	}
+	for i := range walked {
+		if walked[i] != expected[i] {
+			t.Errorf("mismatch (expected: %v, got: %v)", expected, walked)
+		}
+	}
+}
+
+func TestMapAllMatches(t *testing.T) {
+	m := NewMap()
+	for _, e := range []struct {
+		cidr  string
+		value string
+	}{
+		{cidr: "10.1.2.0/24", value: "rack"},
+		{cidr: "10.0.0.0/8", value: "tenant"},
+		{cidr: "10.1.0.0/16", value: "site"},
+		{cidr: "10.1.3.0/24", value: "other rack"},
+		{cidr: "::/0", value: "everything"},
+	} {
+		m.Insert(parseCidrs(e.cidr)[0], e.value)
+	}
+
+	testCases := []struct {
+		ip       string
+		expected []string
+	}{
+		{ip: "10.1.2.3", expected: []string{"::/0 everything", "10.0.0.0/8 tenant", "10.1.0.0/16 site", "10.1.2.0/24 rack"}},
+		{ip: "10.1.4.1", expected: []string{"::/0 everything", "10.0.0.0/8 tenant", "10.1.0.0/16 site"}},
+		{ip: "11.0.0.1", expected: []string{"::/0 everything"}},
+	}
+	for _, tc := range testCases {
+		var got []string
+		for _, match := range m.AllMatches(net.ParseIP(tc.ip)) {
+			got = append(got, match.Prefix.String()+" "+match.Value.(string))
+		}
+
+		if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("mismatch for %s (expected: %v, got: %v)", tc.ip, tc.expected, got)
+		}
+	}
+
+	if matches := NewMap().AllMatches(net.ParseIP("10.0.0.1")); matches != nil {
+		t.Errorf("unexpected matches in empty map %v", matches)
+	}
+}
+
+func TestMapRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cidrs := denseIPv4Cidrs(rng, 2000)
+	m := NewMap()
+	for i, cidr := range cidrs {
+		m.Insert(cidr, i)
+	}
+
+	ip := make(net.IP, 4)
+	for i := 0; i < 10000; i++ {
+		binary.BigEndian.PutUint32(ip, 0x0a000000|uint32(rng.Intn(1<<12)))
+
+		// reference longest prefix match, later inserts of the same prefix win
+		expected, expectedLen := -1, -1
+		for j, cidr := range cidrs {
+			ones, _ := cidr.Mask.Size()
+			if cidr.Contains(ip) && ones >= expectedLen {
+				expected, expectedLen = j, ones
+			}
+		}
+
+		value, _, ok := m.Lookup(ip)
+		if expected == -1 {
+			if ok {
+				t.Fatalf("unexpected match for %s: %v", ip, value)
+			}
+			continue
+		}
+		if !ok || value != expected {
+			t.Fatalf("mismatch for %s (expected: %d, got: %v)", ip, expected, value)
+		}
+	}
+}
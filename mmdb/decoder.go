@@ -0,0 +1,238 @@
+package mmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth limits nesting of decoded values so that corrupted pointer cycles are not followed forever
+const maxDepth = 64
+
+var errInvalidData = errors.New("invalid data section")
+
+// decoder decodes values of data section, pointers are offsets into buf
+type decoder struct {
+	buf []byte
+}
+
+// decode returns value at offset together with offset following it
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("%w: values nested too deep", errInvalidData)
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, next, err
+	}
+
+	// every map entry or array item takes at least a byte, so counts past the end of data are corrupted
+	// and must not be trusted for preallocation
+	if (typ == typeMap || typ == typeArray) && (offset > uint(len(d.buf)) || size > uint(len(d.buf))-offset) {
+		return nil, 0, fmt.Errorf("%w: %d items past the end of data", errInvalidData, size)
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key of type %T", errInvalidData, key)
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("%w: boolean of size %d", errInvalidData, size)
+		}
+		return size == 1, offset, nil
+	}
+
+	b, err := d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double of size %d", errInvalidData, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float of size %d", errInvalidData, size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16:
+		if size > 2 {
+			return nil, 0, fmt.Errorf("%w: uint16 of size %d", errInvalidData, size)
+		}
+		return uint16(decodeUint(b)), offset, nil
+	case typeUint32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("%w: uint32 of size %d", errInvalidData, size)
+		}
+		return uint32(decodeUint(b)), offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("%w: int32 of size %d", errInvalidData, size)
+		}
+		return int32(uint32(decodeUint(b))), offset, nil
+	case typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("%w: uint64 of size %d", errInvalidData, size)
+		}
+		return decodeUint(b), offset, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("%w: uint128 of size %d", errInvalidData, size)
+		}
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("%w: unsupported type %d", errInvalidData, typ)
+}
+
+// control decodes control byte at offset, returns type, size and offset of the payload
+func (d *decoder) control(offset uint) (int, uint, uint, error) {
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	offset++
+
+	typ := int(b[0] >> 5)
+	size := uint(b[0] & 0x1f)
+	if typ == typePointer {
+		return typ, size, offset, nil
+	}
+
+	if typ == typeExtended {
+		ext, err := d.bytes(offset, 1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		offset++
+		typ = int(ext[0]) + 7
+		if typ < typeInt32 || typ > typeFloat {
+			return 0, 0, 0, fmt.Errorf("%w: extended type %d", errInvalidData, typ)
+		}
+	}
+
+	if size >= 29 {
+		n := size - 28
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		offset += n
+
+		switch size {
+		case 29:
+			size = 29 + uint(b[0])
+		case 30:
+			size = 285 + uint(binary.BigEndian.Uint16(b))
+		default:
+			size = 65821 + uint(decodeUint(b))
+		}
+	}
+
+	return typ, size, offset, nil
+}
+
+// pointer decodes pointer with size bits of control byte, returns its target and offset following it
+func (d *decoder) pointer(size, offset uint) (uint, uint, error) {
+	n := size>>3&0x03 + 1
+	b, err := d.bytes(offset, n)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var target uint
+	switch n {
+	case 1:
+		target = (size&0x07)<<8 | uint(b[0])
+	case 2:
+		target = ((size&0x07)<<16 | uint(decodeUint(b))) + 2048
+	case 3:
+		target = ((size&0x07)<<24 | uint(decodeUint(b))) + 526336
+	default:
+		target = uint(binary.BigEndian.Uint32(b))
+	}
+
+	return target, offset + n, nil
+}
+
+func (d *decoder) bytes(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d.buf)) || offset+n < offset {
+		return nil, fmt.Errorf("%w: read of %d bytes at %d past end", errInvalidData, n, offset)
+	}
+
+	return d.buf[offset : offset+n], nil
+}
+
+func decodeUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v
+}
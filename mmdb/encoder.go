@@ -0,0 +1,160 @@
+package mmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// encoder appends values in data section format, pointers are never written
+type encoder struct {
+	buf []byte
+}
+
+// maxSize is the largest size encodable in control byte and following 3 bytes
+const maxSize = 65821 + 1<<24 - 1
+
+func (e *encoder) encode(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		if len(v) > maxSize {
+			return fmt.Errorf("string of %d bytes is too long", len(v))
+		}
+		e.control(typeString, uint(len(v)))
+		e.buf = append(e.buf, v...)
+	case []byte:
+		if len(v) > maxSize {
+			return fmt.Errorf("bytes of length %d are too long", len(v))
+		}
+		e.control(typeBytes, uint(len(v)))
+		e.buf = append(e.buf, v...)
+	case float64:
+		e.control(typeDouble, 8)
+		e.buf = appendUint(e.buf, math.Float64bits(v), 8)
+	case float32:
+		e.control(typeFloat, 4)
+		e.buf = appendUint(e.buf, uint64(math.Float32bits(v)), 4)
+	case bool:
+		size := uint(0)
+		if v {
+			size = 1
+		}
+		e.control(typeBool, size)
+	case uint16:
+		e.uint(typeUint16, uint64(v))
+	case uint32:
+		e.uint(typeUint32, uint64(v))
+	case uint64:
+		e.uint(typeUint64, v)
+	case uint:
+		e.uint(typeUint64, uint64(v))
+	case int32:
+		// negative values take all 4 bytes
+		e.uint(typeInt32, uint64(uint32(v)))
+	case int:
+		switch {
+		case v >= 0 && v <= math.MaxUint32:
+			e.uint(typeUint32, uint64(v))
+		case v >= math.MinInt32 && v < 0:
+			e.uint(typeInt32, uint64(uint32(v)))
+		default:
+			return fmt.Errorf("int %d does not fit into int32 or uint32", v)
+		}
+	case *big.Int:
+		if v.Sign() < 0 || v.BitLen() > 128 {
+			return fmt.Errorf("big.Int %s does not fit into uint128", v)
+		}
+		b := v.Bytes()
+		e.control(typeUint128, uint(len(b)))
+		e.buf = append(e.buf, b...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		e.control(typeMap, uint(len(v)))
+		for _, k := range keys {
+			if err := e.encode(k); err != nil {
+				return err
+			}
+			if err := e.encode(v[k]); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return e.encode(m)
+	case []interface{}:
+		e.control(typeArray, uint(len(v)))
+		for _, item := range v {
+			if err := e.encode(item); err != nil {
+				return err
+			}
+		}
+	case []string:
+		e.control(typeArray, uint(len(v)))
+		for _, s := range v {
+			if err := e.encode(s); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported value type %T", value)
+	}
+
+	return nil
+}
+
+// uint writes unsigned integer without leading zero bytes
+func (e *encoder) uint(typ int, v uint64) {
+	size := uint(0)
+	for x := v; x != 0; x >>= 8 {
+		size++
+	}
+
+	e.control(typ, size)
+	e.buf = appendUint(e.buf, v, size)
+}
+
+func (e *encoder) control(typ int, size uint) {
+	var ctrl byte
+	var ext []byte
+	if typ > typeMap {
+		ext = []byte{byte(typ - 7)}
+	} else {
+		ctrl = byte(typ) << 5
+	}
+
+	switch {
+	case size < 29:
+		e.buf = append(e.buf, ctrl|byte(size))
+		e.buf = append(e.buf, ext...)
+	case size < 285:
+		e.buf = append(e.buf, ctrl|29)
+		e.buf = append(e.buf, ext...)
+		e.buf = append(e.buf, byte(size-29))
+	case size < 65821:
+		e.buf = append(e.buf, ctrl|30)
+		e.buf = append(e.buf, ext...)
+		e.buf = appendUint(e.buf, uint64(size-285), 2)
+	default:
+		e.buf = append(e.buf, ctrl|31)
+		e.buf = append(e.buf, ext...)
+		e.buf = appendUint(e.buf, uint64(size-65821), 3)
+	}
+}
+
+// appendUint appends n least significant bytes of v in big endian order
+func appendUint(buf []byte, v uint64, n uint) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+
+	return append(buf, b[8-n:]...)
+}
@@ -0,0 +1,422 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kentik/ipset"
+)
+
+func TestDecode(t *testing.T) {
+	long := strings.Repeat("x", 70000)
+	testCases := []struct {
+		desc     string
+		buf      []byte
+		offset   uint
+		expected interface{}
+	}{
+		{desc: "string", buf: []byte{0x43, 'a', 'b', 'c'}, expected: "abc"},
+		{desc: "empty string", buf: []byte{0x40}, expected: ""},
+		{desc: "uint16", buf: []byte{0xa2, 0x01, 0xf4}, expected: uint16(500)},
+		{desc: "uint32 zero", buf: []byte{0xc0}, expected: uint32(0)},
+		{desc: "int32 negative", buf: []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xfe}, expected: int32(-2)},
+		{desc: "uint64", buf: []byte{0x03, 0x02, 0x01, 0x00, 0x00}, expected: uint64(1 << 16)},
+		{desc: "uint128", buf: []byte{0x01, 0x03, 0x01}, expected: big.NewInt(1)},
+		{desc: "bool", buf: []byte{0x01, 0x07}, expected: true},
+		{desc: "double", buf: []byte{0x68, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}, expected: 1.0},
+		{desc: "float", buf: []byte{0x04, 0x08, 0x3f, 0x80, 0, 0}, expected: float32(1)},
+		{desc: "bytes", buf: []byte{0x82, 0x01, 0x02}, expected: []byte{1, 2}},
+		{
+			desc:     "map",
+			buf:      []byte{0xe2, 0x42, 'e', 'n', 0x43, 'G', 'e', 'r', 0x42, 'd', 'e', 0x44, 'D', 'e', 'u', 't'},
+			expected: map[string]interface{}{"en": "Ger", "de": "Deut"},
+		},
+		{
+			desc:     "array",
+			buf:      []byte{0x02, 0x04, 0x42, 'e', 'n', 0xa1, 0x07},
+			expected: []interface{}{"en", uint16(7)},
+		},
+		{
+			desc:     "pointer",
+			buf:      []byte{0x42, 'e', 'n', 0xe1, 0x20, 0x00, 0x20, 0x00},
+			offset:   3,
+			expected: map[string]interface{}{"en": "en"},
+		},
+		{
+			desc:     "string of extended size",
+			buf:      append([]byte{0x5f, 0x00, 0x10, 0x53}, long...),
+			expected: long,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := decoder{buf: tc.buf}
+			value, next, err := d.decode(tc.offset, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, tc.expected) {
+				t.Errorf("mismatch (expected: %#v, got: %#v)", tc.expected, value)
+			}
+			if next != uint(len(tc.buf)) {
+				t.Errorf("unexpected next offset %d", next)
+			}
+
+			// values written back decode the same
+			var e encoder
+			if err := e.encode(value); err != nil {
+				t.Fatal(err)
+			}
+			d = decoder{buf: e.buf}
+			if decoded, _, err := d.decode(0, 0); err != nil || !reflect.DeepEqual(decoded, value) {
+				t.Errorf("round trip mismatch (expected: %#v, got: %#v, err: %v)", value, decoded, err)
+			}
+		})
+	}
+}
+
+func TestDecodeHugeCount(t *testing.T) {
+	for desc, buf := range map[string][]byte{
+		"map":   {0xff, 0xff, 0xff, 0xff, 0x40},
+		"array": {0x1f, 0x04, 0xff, 0xff, 0xff, 0x40},
+	} {
+		d := decoder{buf: buf}
+		if _, _, err := d.decode(0, 0); !errors.Is(err, errInvalidData) {
+			t.Errorf("%s of 16M items in a few bytes was decoded: %v", desc, err)
+		}
+	}
+}
+
+func TestDecodePointers(t *testing.T) {
+	testCases := []struct {
+		ptr    []byte
+		target uint
+	}{
+		{ptr: []byte{0x20, 0x05}, target: 5},
+		{ptr: []byte{0x28, 0x00, 0x05}, target: 2053},
+		{ptr: []byte{0x30, 0x00, 0x00, 0x05}, target: 526341},
+		{ptr: []byte{0x38, 0x00, 0x10, 0x00, 0x00}, target: 1 << 20},
+	}
+	for _, tc := range testCases {
+		d := decoder{}
+		target, next, err := d.pointer(uint(tc.ptr[0]&0x1f), 0)
+		if err == nil {
+			t.Errorf("pointer read past the end")
+		}
+
+		d.buf = tc.ptr[1:]
+		if target, next, err = d.pointer(uint(tc.ptr[0]&0x1f), 0); err != nil || target != tc.target || next != uint(len(tc.ptr)-1) {
+			t.Errorf("mismatch for %x (expected: %d, got: %d, %d, %v)", tc.ptr, tc.target, target, next, err)
+		}
+	}
+
+	// pointer to itself
+	d := decoder{buf: []byte{0x20, 0x00}}
+	if _, _, err := d.decode(0, 0); err == nil {
+		t.Errorf("pointer cycle was decoded")
+	}
+}
+
+func asn(number uint32, org string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       number,
+		"autonomous_system_organization": org,
+	}
+}
+
+func testMap() *ipset.Map {
+	m := ipset.NewMap()
+	for _, e := range []struct {
+		cidr  string
+		value interface{}
+	}{
+		{cidr: "8.8.8.0/24", value: asn(15169, "GOOGLE")},
+		{cidr: "1.0.0.0/8", value: asn(13335, "CLOUDFLARENET")},
+		{cidr: "1.1.1.0/24", value: asn(13335, "CLOUDFLARENET")},
+		{cidr: "1.1.1.1/32", value: "resolver"},
+		{cidr: "2001:4860::/32", value: asn(15169, "GOOGLE")},
+		{cidr: "2001:4860:4860::8888/128", value: []interface{}{"resolver", true, 1.5}},
+	} {
+		_, cidr, err := net.ParseCIDR(e.cidr)
+		if err != nil {
+			panic(err)
+		}
+		m.Insert(cidr, e.value)
+	}
+
+	return m
+}
+
+func TestWriteRead(t *testing.T) {
+	testCases := []struct {
+		ip       string
+		expected interface{}
+		network  string
+	}{
+		{ip: "8.8.8.8", expected: asn(15169, "GOOGLE"), network: "8.8.8.0/24"},
+		{ip: "::ffff:8.8.8.4", expected: asn(15169, "GOOGLE"), network: "8.8.8.0/24"},
+		{ip: "8.8.4.4", network: "8.8.0.0/21"},
+		{ip: "1.1.1.1", expected: "resolver", network: "1.1.1.1/32"},
+		{ip: "1.1.1.2", expected: asn(13335, "CLOUDFLARENET"), network: "1.1.1.2/31"},
+		{ip: "1.2.3.4", expected: asn(13335, "CLOUDFLARENET"), network: "1.2.0.0/15"},
+		{ip: "9.9.9.9", network: "9.0.0.0/8"},
+		{ip: "2001:4860:4860::8888", expected: []interface{}{"resolver", true, 1.5}, network: "2001:4860:4860::8888/128"},
+		{ip: "2001:4860:4860::8844", expected: asn(15169, "GOOGLE"), network: "2001:4860:4860::8800/121"},
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		var buf bytes.Buffer
+		opts := Options{DatabaseType: "Test-ASN", RecordSize: recordSize, Languages: []string{"en"}}
+		if err := Write(&buf, testMap(), opts); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := FromBytes(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Metadata.DatabaseType != "Test-ASN" || r.Metadata.RecordSize != uint16(recordSize) ||
+			r.Metadata.IPVersion != 6 || !reflect.DeepEqual(r.Metadata.Languages, []string{"en"}) {
+			t.Errorf("unexpected metadata %+v", r.Metadata)
+		}
+
+		for _, tc := range testCases {
+			value, network, err := r.Lookup(net.ParseIP(tc.ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, tc.expected) || network.String() != tc.network {
+				t.Errorf("mismatch for %s in %d bit records (expected: %v %s, got: %v %s)",
+					tc.ip, recordSize, tc.expected, tc.network, value, network)
+			}
+		}
+
+		// ipv4 mapped addresses are aliased to ::/96
+		if value, err := lookupMapped(r, "8.8.8.8"); err != nil || !reflect.DeepEqual(value, asn(15169, "GOOGLE")) {
+			t.Errorf("unexpected value of ipv4 mapped address %v (err: %v)", value, err)
+		}
+	}
+}
+
+// lookupMapped returns value stored for ipv4 mapped address of ip, which Lookup takes as ipv4
+func lookupMapped(r *Reader, ip string) (interface{}, error) {
+	mapped := net.ParseIP("::ffff:" + ip)
+	node := uint(0)
+	for depth := uint(0); depth < 128 && node < r.nodeCount; depth++ {
+		node = r.record(node, uint(mapped[depth/8]>>(7-depth%8)&0x01))
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+
+	value, _, err := r.data.decode(node-r.nodeCount-16, 0)
+	return value, err
+}
+
+func TestAliasIPv4Covered(t *testing.T) {
+	for _, cidr := range []string{"::/0", "::/64", "::ff00:0:0/88"} {
+		_, enclosing, _ := net.ParseCIDR(cidr)
+		m := ipset.NewMap()
+		m.Insert(enclosing, "ipv6")
+		m.Insert(&net.IPNet{IP: net.IPv4(8, 8, 8, 0).To4(), Mask: net.CIDRMask(24, 32)}, "ipv4")
+
+		var buf bytes.Buffer
+		if err := Write(&buf, m, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		r, err := FromBytes(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value, _, err := r.Lookup(net.ParseIP("8.8.8.8")); err != nil || value != "ipv4" {
+			t.Errorf("unexpected value of ipv4 address within %s: %v (err: %v)", cidr, value, err)
+		}
+		if value, err := lookupMapped(r, "8.8.8.8"); err != nil || value != "ipv6" {
+			t.Errorf("ipv4 data replaced %s at mapped address: %v (err: %v)", cidr, value, err)
+		}
+		if value, err := lookupMapped(r, "9.9.9.9"); err != nil || value != "ipv6" {
+			t.Errorf("ipv4 data replaced %s at mapped address: %v (err: %v)", cidr, value, err)
+		}
+	}
+}
+
+func TestIPv4Database(t *testing.T) {
+	m := ipset.NewMap()
+	m.Insert(&net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, "world")
+	m.Insert(&net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}, "private")
+
+	var buf bytes.Buffer
+	if err := Write(&buf, m, Options{IPVersion: 4}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, network, err := r.Lookup(net.ParseIP("10.1.1.1")); err != nil || value != "private" || network.String() != "10.0.0.0/8" {
+		t.Errorf("unexpected lookup result %v %s %v", value, network, err)
+	}
+	if value, network, err := r.Lookup(net.ParseIP("192.0.2.1")); err != nil || value != "world" || network.String() != "128.0.0.0/1" {
+		t.Errorf("unexpected lookup result %v %s %v", value, network, err)
+	}
+	if _, _, err := r.Lookup(net.ParseIP("2001:db8::1")); err == nil {
+		t.Errorf("ipv6 address was looked up in ipv4 database")
+	}
+
+	m.Insert(&net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}, "doc")
+	if err := Write(&buf, m, Options{IPVersion: 4}); err == nil {
+		t.Errorf("ipv6 network was written into ipv4 database")
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	m := ipset.NewMap()
+	m.Insert(&net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}, struct{}{})
+	if err := Write(&bytes.Buffer{}, m, Options{}); err == nil {
+		t.Errorf("unsupported value was written")
+	}
+
+	long := strings.Repeat("x", maxSize+1)
+	for desc, value := range map[string]interface{}{
+		"map key":      map[string]interface{}{long: "value"},
+		"array string": []string{"short", long},
+	} {
+		m = ipset.NewMap()
+		m.Insert(&net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}, value)
+		if err := Write(&bytes.Buffer{}, m, Options{}); err == nil {
+			t.Errorf("%s too long to encode was written", desc)
+		}
+	}
+
+	m = ipset.NewMap()
+	m.Insert(&net.IPNet{IP: net.ParseIP("::"), Mask: net.CIDRMask(100, 128)}, "compatible")
+	if err := Write(&bytes.Buffer{}, m, Options{}); err == nil {
+		t.Errorf("network colliding with ipv4 networks was written")
+	}
+
+	m = ipset.NewMap()
+	m.Insert(&net.IPNet{IP: net.ParseIP("::"), Mask: net.CIDRMask(96, 128)}, "compatible")
+	m.Insert(&net.IPNet{IP: net.IPv4(0, 0, 0, 0).To4(), Mask: net.CIDRMask(0, 32)}, "ipv4")
+	if err := Write(&bytes.Buffer{}, m, Options{}); err == nil {
+		t.Errorf("network of the ipv4 subtree was written")
+	}
+}
+
+func TestToMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	m := ipset.NewMap()
+	for i := 0; i < 2000; i++ {
+		ones := 8 + rng.Intn(25)
+		mask := net.CIDRMask(ones, 32)
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, 0x0a000000|rng.Uint32()&0x00ffffff)
+		m.Insert(&net.IPNet{IP: ip.Mask(mask), Mask: mask}, uint32(rng.Intn(50)))
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, m, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := r.ToMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := make(net.IP, 4)
+	for i := 0; i < 10000; i++ {
+		binary.BigEndian.PutUint32(ip, 0x0a000000|rng.Uint32()&0x00ffffff)
+		expected, _, expectedOK := m.Lookup(ip)
+		value, _, ok := imported.Lookup(ip)
+		if ok != expectedOK || value != expected {
+			t.Fatalf("mismatch for %s (expected: %v, got: %v)", ip, expected, value)
+		}
+	}
+}
+
+func TestFixture(t *testing.T) {
+	var buf bytes.Buffer
+	opts := Options{
+		DatabaseType: "Test-ASN",
+		Description:  map[string]string{"en": "Test ASN database"},
+		Languages:    []string{"en"},
+		BuildTime:    time.Unix(1697500000, 0),
+	}
+	if err := Write(&buf, testMap(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := ioutil.ReadFile("testdata/test-asn.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), fixture) {
+		t.Errorf("written database differs from fixture")
+	}
+
+	r, err := Open("testdata/test-asn.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Metadata.BuildTime.Equal(opts.BuildTime) || r.Metadata.Description["en"] != "Test ASN database" || r.Metadata.RecordSize != 24 {
+		t.Errorf("unexpected metadata %+v", r.Metadata)
+	}
+
+	var networks []string
+	err = r.Walk(func(network *net.IPNet, value interface{}) error {
+		if _, ok := value.(map[string]interface{}); ok && len(network.IP) == net.IPv4len {
+			networks = append(networks, network.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1.0.0.0/8 is split around 1.1.1.0/24, which in turn is split around 1.1.1.1/32
+	expected := []string{
+		"1.0.0.0/16", "1.1.0.0/24", "1.1.1.0/32", "1.1.1.2/31", "1.1.1.4/30", "1.1.1.8/29", "1.1.1.16/28",
+		"1.1.1.32/27", "1.1.1.64/26", "1.1.1.128/25", "1.1.2.0/23", "1.1.4.0/22", "1.1.8.0/21", "1.1.16.0/20",
+		"1.1.32.0/19", "1.1.64.0/18", "1.1.128.0/17", "1.2.0.0/15", "1.4.0.0/14", "1.8.0.0/13", "1.16.0.0/12",
+		"1.32.0.0/11", "1.64.0.0/10", "1.128.0.0/9", "8.8.8.0/24",
+	}
+	if !reflect.DeepEqual(networks, expected) {
+		t.Errorf("mismatch (expected: %v, got: %v)", expected, networks)
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testMap(), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	db := buf.Bytes()
+
+	if _, err := FromBytes(db[:len(db)/2]); err == nil {
+		t.Errorf("truncated database was read")
+	}
+
+	// data section cut short while metadata stays in place
+	i := bytes.Index(db, metadataMarker)
+	corrupted := append(append([]byte(nil), db[:i-20]...), db[i:]...)
+	r, err := FromBytes(corrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Lookup(net.ParseIP("2001:4860:4860::8888")); err == nil {
+		t.Errorf("value past the data section was read")
+	}
+}
@@ -0,0 +1,324 @@
+// Package mmdb reads and writes MaxMind DB files, following the MaxMind DB File Format Specification 2.0.
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/kentik/ipset"
+	"lukechampine.com/uint128"
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// metadataSearchLength is the size of the file tail holding metadata
+const metadataSearchLength = 128 * 1024
+
+// Metadata describes the database
+type Metadata struct {
+	BinaryFormatMajorVersion uint16
+	BinaryFormatMinorVersion uint16
+	BuildTime                time.Time
+	DatabaseType             string
+	Description              map[string]string
+	IPVersion                uint16
+	Languages                []string
+	NodeCount                uint32
+	RecordSize               uint16
+}
+
+// Reader looks up addresses in database kept in memory
+type Reader struct {
+	Metadata Metadata
+
+	tree      []byte
+	data      decoder
+	nodeCount uint
+	nodeSize  uint
+	// ipv4Start is record reached from the root following 96 zero bits, or less when a data record comes first
+	ipv4Start uint
+	ipv4Depth uint
+}
+
+// Open reads database file into memory
+func Open(path string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: %w", err)
+	}
+
+	return FromBytes(buf)
+}
+
+// FromBytes constructs Reader of database held in buf, buf must not be modified afterwards
+func FromBytes(buf []byte) (*Reader, error) {
+	tail := buf
+	if len(tail) > metadataSearchLength {
+		tail = tail[len(tail)-metadataSearchLength:]
+	}
+	i := bytes.LastIndex(tail, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("mmdb: metadata not found")
+	}
+	metaStart := len(buf) - len(tail) + i
+
+	meta, err := parseMetadata(buf[metaStart+len(metadataMarker):])
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: metadata: %w", err)
+	}
+	if meta.BinaryFormatMajorVersion != 2 {
+		return nil, fmt.Errorf("mmdb: unsupported format version %d", meta.BinaryFormatMajorVersion)
+	}
+	if meta.RecordSize != 24 && meta.RecordSize != 28 && meta.RecordSize != 32 {
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", meta.RecordSize)
+	}
+	if meta.IPVersion != 4 && meta.IPVersion != 6 {
+		return nil, fmt.Errorf("mmdb: unsupported ip version %d", meta.IPVersion)
+	}
+
+	r := &Reader{
+		Metadata:  meta,
+		nodeCount: uint(meta.NodeCount),
+		nodeSize:  uint(meta.RecordSize) / 4,
+	}
+	treeSize := r.nodeCount * r.nodeSize
+	if treeSize+16 > uint(metaStart) {
+		return nil, fmt.Errorf("mmdb: search tree of %d nodes exceeds file", r.nodeCount)
+	}
+	r.tree = buf[:treeSize]
+	r.data = decoder{buf: buf[treeSize+16 : metaStart]}
+
+	if meta.IPVersion == 6 {
+		for r.ipv4Depth < 96 && r.ipv4Start < r.nodeCount {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+			r.ipv4Depth++
+		}
+	}
+
+	return r, nil
+}
+
+func parseMetadata(buf []byte) (Metadata, error) {
+	d := decoder{buf: buf}
+	v, _, err := d.decode(0, 0)
+	if err != nil {
+		return Metadata{}, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return Metadata{}, fmt.Errorf("unexpected type %T", v)
+	}
+
+	var meta Metadata
+	fields := []struct {
+		key      string
+		dst      interface{}
+		optional bool
+	}{
+		{key: "binary_format_major_version", dst: &meta.BinaryFormatMajorVersion},
+		{key: "binary_format_minor_version", dst: &meta.BinaryFormatMinorVersion},
+		{key: "database_type", dst: &meta.DatabaseType},
+		{key: "ip_version", dst: &meta.IPVersion},
+		{key: "node_count", dst: &meta.NodeCount},
+		{key: "record_size", dst: &meta.RecordSize},
+	}
+	for _, f := range fields {
+		value, ok := m[f.key]
+		if !ok {
+			return Metadata{}, fmt.Errorf("missing %s", f.key)
+		}
+
+		switch dst := f.dst.(type) {
+		case *uint16:
+			v, ok := value.(uint16)
+			if !ok {
+				return Metadata{}, fmt.Errorf("%s of type %T", f.key, value)
+			}
+			*dst = v
+		case *uint32:
+			v, ok := value.(uint32)
+			if !ok {
+				return Metadata{}, fmt.Errorf("%s of type %T", f.key, value)
+			}
+			*dst = v
+		case *string:
+			v, ok := value.(string)
+			if !ok {
+				return Metadata{}, fmt.Errorf("%s of type %T", f.key, value)
+			}
+			*dst = v
+		}
+	}
+
+	if epoch, ok := m["build_epoch"].(uint64); ok {
+		meta.BuildTime = time.Unix(int64(epoch), 0).UTC()
+	}
+	if languages, ok := m["languages"].([]interface{}); ok {
+		for _, l := range languages {
+			if s, ok := l.(string); ok {
+				meta.Languages = append(meta.Languages, s)
+			}
+		}
+	}
+	if description, ok := m["description"].(map[string]interface{}); ok {
+		meta.Description = make(map[string]string, len(description))
+		for k, v := range description {
+			if s, ok := v.(string); ok {
+				meta.Description[k] = s
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+// record returns left or right record of node
+func (r *Reader) record(node, bit uint) uint {
+	b := r.tree[node*r.nodeSize : (node+1)*r.nodeSize]
+	switch r.nodeSize {
+	case 6:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 7:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Lookup returns value of the network containing ip together with the network,
+// value is nil when the database holds no data for ip
+func (r *Reader) Lookup(ip net.IP) (interface{}, *net.IPNet, error) {
+	var addr []byte
+	var node, depth, bits uint
+	if ipv4 := ip.To4(); ipv4 != nil {
+		addr, bits = ipv4, 32
+		if r.Metadata.IPVersion == 6 {
+			node, depth, bits = r.ipv4Start, r.ipv4Depth, 128
+			addr = append(make([]byte, 12, 16), ipv4...)
+		}
+	} else if ipv6 := ip.To16(); ipv6 != nil && r.Metadata.IPVersion == 6 {
+		addr, bits = ipv6, 128
+	} else {
+		return nil, nil, fmt.Errorf("mmdb: can't look up %v in ipv%d database", ip, r.Metadata.IPVersion)
+	}
+
+	for ; depth < bits && node < r.nodeCount; depth++ {
+		node = r.record(node, uint(addr[depth/8]>>(7-depth%8)&0x01))
+	}
+	if node < r.nodeCount {
+		return nil, nil, errors.New("mmdb: search tree deeper than address")
+	}
+
+	if bits == 128 && ip.To4() != nil && depth < 96 {
+		// data record covers the whole ipv4 space
+		depth = 96
+	}
+	network := r.network(addr, depth)
+	if node == r.nodeCount {
+		return nil, network, nil
+	}
+
+	value, _, err := r.data.decode(node-r.nodeCount-16, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmdb: %w", err)
+	}
+
+	return value, network, nil
+}
+
+// network returns network of depth leading bits of addr, networks within ::/96 of ipv6 database are ipv4 ones
+func (r *Reader) network(addr []byte, depth uint) *net.IPNet {
+	if len(addr) == 16 && depth >= 96 && bytes.Equal(addr[:12], make([]byte, 12)) {
+		addr, depth = addr[12:], depth-96
+	}
+
+	bits := 8 * len(addr)
+	mask := net.CIDRMask(int(depth), bits)
+	return &net.IPNet{IP: net.IP(addr).Mask(mask), Mask: mask}
+}
+
+var (
+	ipv4MappedPrefix = uint128.New(0xffff00000000, 0)
+	sixToFourPrefix  = uint128.New(0, 0x2002<<48)
+)
+
+// Walk calls fn for every network holding data, networks are visited in ascending order and
+// ipv4 aliases of ipv6 databases at ::ffff:0:0/96 and 2002::/16 are skipped. Values of networks
+// sharing the same data are decoded once and shared.
+func (r *Reader) Walk(fn func(network *net.IPNet, value interface{}) error) error {
+	bits := uint(32)
+	if r.Metadata.IPVersion == 6 {
+		bits = 128
+	}
+	cache := make(map[uint]interface{})
+
+	var visit func(node uint, addr uint128.Uint128, depth uint) error
+	visit = func(node uint, addr uint128.Uint128, depth uint) error {
+		if bits == 128 && r.ipv4Depth == 96 && node == r.ipv4Start &&
+			(depth == 96 && addr.Equals(ipv4MappedPrefix) || depth == 16 && addr.Equals(sixToFourPrefix)) {
+			return nil
+		}
+
+		if node > r.nodeCount {
+			offset := node - r.nodeCount - 16
+			value, ok := cache[offset]
+			if !ok {
+				var err error
+				if value, _, err = r.data.decode(offset, 0); err != nil {
+					return fmt.Errorf("mmdb: %w", err)
+				}
+				cache[offset] = value
+			}
+
+			var b [16]byte
+			binary.BigEndian.PutUint64(b[:8], addr.Hi)
+			binary.BigEndian.PutUint64(b[8:], addr.Lo)
+			ip := b[:]
+			if bits == 32 {
+				ip, depth = b[12:], depth-96
+			}
+			return fn(r.network(ip, depth), value)
+		}
+		if node == r.nodeCount {
+			return nil
+		}
+		if depth == 128 {
+			return errors.New("mmdb: search tree deeper than address")
+		}
+
+		if err := visit(r.record(node, 0), addr, depth+1); err != nil {
+			return err
+		}
+		return visit(r.record(node, 1), addr.Or(uint128.From64(1).Lsh(127-depth)), depth+1)
+	}
+
+	if bits == 32 {
+		// ipv4 tree is walked as if it was nested at ::/96
+		return visit(0, uint128.Zero, 96)
+	}
+
+	return visit(0, uint128.Zero, 0)
+}
+
+// ToMap imports networks of the database into Map
+func (r *Reader) ToMap() (*ipset.Map, error) {
+	m := ipset.NewMap()
+	err := r.Walk(func(network *net.IPNet, value interface{}) error {
+		m.Insert(network, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
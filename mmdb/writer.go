@@ -0,0 +1,269 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/kentik/ipset"
+)
+
+// Options of written database
+type Options struct {
+	DatabaseType string
+	Description  map[string]string
+	Languages    []string
+	// IPVersion is 6 by default, ipv4 databases can't hold ipv6 networks
+	IPVersion int
+	// RecordSize is 24, 28 or 32, the smallest one fitting the database is used by default
+	RecordSize int
+	// BuildTime is the current time by default
+	BuildTime time.Time
+}
+
+type recordKind uint8
+
+const (
+	recordEmpty recordKind = iota
+	recordNode
+	recordData
+)
+
+// record of the search tree under construction, value is node index or data section offset
+type record struct {
+	kind  recordKind
+	value uint32
+}
+
+// writer builds the search tree, node at index 0 is the root
+type writer struct {
+	nodes   [][2]record
+	data    encoder
+	offsets map[string]uint32
+}
+
+// Write stores networks of m with their values as database. In ipv6 databases ipv4 networks are
+// stored at ::/96 and aliased at ::ffff:0:0/96, unless ipv6 networks of m already cover it. Ipv6 networks
+// within ::/96, ::/96 included, collide with ipv4 ones and are rejected. Values
+// may be string, []byte, bool, float32, float64, uint16, uint32, uint64, uint, int32, int, *big.Int,
+// []string, map[string]string and []interface{} or map[string]interface{} holding them.
+func Write(w io.Writer, m *ipset.Map, opts Options) error {
+	if opts.IPVersion == 0 {
+		opts.IPVersion = 6
+	}
+	if opts.IPVersion != 4 && opts.IPVersion != 6 {
+		return fmt.Errorf("mmdb: unsupported ip version %d", opts.IPVersion)
+	}
+	if opts.BuildTime.IsZero() {
+		opts.BuildTime = time.Now()
+	}
+
+	wr := &writer{nodes: make([][2]record, 1), offsets: make(map[string]uint32)}
+
+	// Walk visits enclosing networks first, so more specific ones are inserted into their expanded records
+	var err error
+	m.Walk(func(network *net.IPNet, value interface{}) bool {
+		addr, depth, keyErr := key(network, opts.IPVersion)
+		if keyErr != nil {
+			err = fmt.Errorf("mmdb: %w", keyErr)
+			return false
+		}
+
+		rec, storeErr := wr.store(value)
+		if storeErr != nil {
+			err = fmt.Errorf("mmdb: %s: %w", network, storeErr)
+			return false
+		}
+
+		wr.insert(addr, depth, rec)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.IPVersion == 6 {
+		wr.aliasIPv4()
+	}
+
+	return wr.write(w, opts)
+}
+
+// key returns address bits of network in the database tree and their count
+func key(network *net.IPNet, ipVersion int) ([]byte, uint, error) {
+	ones, bits := network.Mask.Size()
+	if ipv4 := network.IP.To4(); bits == 32 && ipv4 != nil {
+		if ipVersion == 4 {
+			return ipv4, uint(ones), nil
+		}
+		return append(make([]byte, 12, 16), ipv4...), uint(ones) + 96, nil
+	}
+
+	if ipVersion == 4 {
+		return nil, 0, fmt.Errorf("ipv6 network %s in ipv4 database", network)
+	}
+	// ::/96 itself is the node of the ipv4 subtree
+	if ones >= 96 && bytes.Equal(network.IP[:12], make([]byte, 12)) {
+		return nil, 0, fmt.Errorf("network %s collides with ipv4 networks", network)
+	}
+
+	return network.IP.To16(), uint(ones), nil
+}
+
+// store appends value to data section unless the same one was stored before
+func (w *writer) store(value interface{}) (record, error) {
+	var e encoder
+	if err := e.encode(value); err != nil {
+		return record{}, err
+	}
+
+	offset, ok := w.offsets[string(e.buf)]
+	if !ok {
+		offset = uint32(len(w.data.buf))
+		w.offsets[string(e.buf)] = offset
+		w.data.buf = append(w.data.buf, e.buf...)
+	}
+
+	return record{kind: recordData, value: offset}, nil
+}
+
+// insert sets record of the network of depth leading bits of addr, records of enclosing
+// networks met on the way are expanded into nodes pointing twice to their data
+func (w *writer) insert(addr []byte, depth uint, rec record) {
+	if depth == 0 {
+		w.nodes[0] = [2]record{rec, rec}
+		return
+	}
+
+	node := uint32(0)
+	for i := uint(0); ; i++ {
+		bit := addr[i/8] >> (7 - i%8) & 0x01
+		if i == depth-1 {
+			w.nodes[node][bit] = rec
+			return
+		}
+
+		next := w.nodes[node][bit]
+		if next.kind != recordNode {
+			w.nodes = append(w.nodes, [2]record{next, next})
+			next = record{kind: recordNode, value: uint32(len(w.nodes) - 1)}
+			w.nodes[node][bit] = next
+		}
+		node = next.value
+	}
+}
+
+// aliasIPv4 points ::ffff:0:0/96 to the ipv4 subtree at ::/96, unless ipv6 networks already
+// cover the range with other records
+func (w *writer) aliasIPv4() {
+	mapped := make([]byte, 16)
+	mapped[10], mapped[11] = 0xff, 0xff
+
+	ipv4 := w.lookup(make([]byte, 16), 96)
+	if ipv4.kind == recordEmpty {
+		return
+	}
+	if existing := w.lookup(mapped, 96); existing.kind != recordEmpty {
+		return
+	}
+
+	w.insert(mapped, 96, ipv4)
+}
+
+// lookup returns record of the network of depth leading bits of addr, or of the network enclosing it
+func (w *writer) lookup(addr []byte, depth uint) record {
+	rec := record{kind: recordNode}
+	for i := uint(0); i < depth && rec.kind == recordNode; i++ {
+		rec = w.nodes[rec.value][addr[i/8]>>(7-i%8)&0x01]
+	}
+
+	return rec
+}
+
+func (w *writer) write(out io.Writer, opts Options) error {
+	nodeCount := uint64(len(w.nodes))
+	maxRecord := nodeCount + 16 + uint64(len(w.data.buf))
+
+	recordSize := opts.RecordSize
+	if recordSize == 0 {
+		for _, size := range []int{24, 28, 32} {
+			if maxRecord < 1<<uint(size) {
+				recordSize = size
+				break
+			}
+		}
+	}
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return fmt.Errorf("mmdb: unsupported record size %d", opts.RecordSize)
+	}
+	if maxRecord >= 1<<uint(recordSize) {
+		return errors.New("mmdb: database too large for record size")
+	}
+
+	nodeSize := recordSize / 4
+	tree := make([]byte, len(w.nodes)*nodeSize, len(w.nodes)*nodeSize+16)
+	for i, n := range w.nodes {
+		var values [2]uint32
+		for bit, rec := range n {
+			switch rec.kind {
+			case recordEmpty:
+				values[bit] = uint32(nodeCount)
+			case recordNode:
+				values[bit] = rec.value
+			case recordData:
+				values[bit] = uint32(nodeCount) + 16 + rec.value
+			}
+		}
+
+		b := tree[i*nodeSize : (i+1)*nodeSize]
+		switch nodeSize {
+		case 6:
+			b[0], b[1], b[2] = byte(values[0]>>16), byte(values[0]>>8), byte(values[0])
+			b[3], b[4], b[5] = byte(values[1]>>16), byte(values[1]>>8), byte(values[1])
+		case 7:
+			b[0], b[1], b[2] = byte(values[0]>>16), byte(values[0]>>8), byte(values[0])
+			b[3] = byte(values[0]>>20)&0xf0 | byte(values[1]>>24)&0x0f
+			b[4], b[5], b[6] = byte(values[1]>>16), byte(values[1]>>8), byte(values[1])
+		default:
+			binary.BigEndian.PutUint32(b, values[0])
+			binary.BigEndian.PutUint32(b[4:], values[1])
+		}
+	}
+	tree = append(tree, make([]byte, 16)...)
+
+	var meta encoder
+	description := opts.Description
+	if description == nil {
+		description = map[string]string{}
+	}
+	languages := opts.Languages
+	if languages == nil {
+		languages = []string{}
+	}
+	err := meta.encode(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(opts.BuildTime.Unix()),
+		"database_type":               opts.DatabaseType,
+		"description":                 description,
+		"ip_version":                  uint16(opts.IPVersion),
+		"languages":                   languages,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	if err != nil {
+		return fmt.Errorf("mmdb: metadata: %w", err)
+	}
+
+	for _, b := range [][]byte{tree, w.data.buf, metadataMarker, meta.buf} {
+		if _, err := out.Write(b); err != nil {
+			return fmt.Errorf("mmdb: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,417 @@
+// Package mrt reads BGP routing tables from MRT TABLE_DUMP_V2 files (RFC 6396), like the ones
+// published by RouteViews and RIPE RIS.
+package mrt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	typeTableDumpV2 = 13
+
+	subtypePeerIndexTable        = 1
+	subtypeRIBIPv4Unicast        = 2
+	subtypeRIBIPv6Unicast        = 4
+	subtypeRIBIPv4UnicastAddPath = 8
+	subtypeRIBIPv6UnicastAddPath = 10
+
+	attrFlagExtendedLength = 0x10
+	attrTypeASPath         = 2
+
+	segmentASSet            = 1
+	segmentASConfedSequence = 3
+	segmentASConfedSet      = 4
+
+	headerLength = 12
+	// maxRecordLength guards against allocating huge buffers for corrupted length fields
+	maxRecordLength = 16 * 1024 * 1024
+)
+
+var errTruncated = errors.New("truncated record")
+
+// Peer is an entry of PEER_INDEX_TABLE, RIB entries refer to peers by index
+type Peer struct {
+	BGPID net.IP
+	IP    net.IP
+	AS    uint32
+}
+
+// ASPathSegment is a sequence or unordered set of ASNs, Confed marks segments of AS confederation
+// member ASNs (RFC 5065)
+type ASPathSegment struct {
+	Set    bool
+	Confed bool
+	ASNs   []uint32
+}
+
+// ASPath is a list of segments, the origin AS goes last
+type ASPath []ASPathSegment
+
+// Origin returns AS originating the route, path ending with a set has no single origin
+// unless the set has one member. Confederation segments are skipped, they are local
+// to the confederation.
+func (p ASPath) Origin() (uint32, bool) {
+	for i := len(p) - 1; i >= 0; i-- {
+		last := p[i]
+		if last.Confed {
+			continue
+		}
+		if len(last.ASNs) == 0 || last.Set && len(last.ASNs) > 1 {
+			return 0, false
+		}
+
+		return last.ASNs[len(last.ASNs)-1], true
+	}
+
+	return 0, false
+}
+
+// Len returns path length as counted by BGP best path selection, sets count as a single hop
+// and confederation segments don't count at all
+func (p ASPath) Len() int {
+	length := 0
+	for _, s := range p {
+		switch {
+		case s.Confed:
+		case s.Set:
+			length++
+		default:
+			length += len(s.ASNs)
+		}
+	}
+
+	return length
+}
+
+// String formats path as bgpdump does, sets in braces, confederation sequences in parentheses
+// and confederation sets in brackets
+func (p ASPath) String() string {
+	var b strings.Builder
+	for i, s := range p {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		start, end := segmentDelimiters(s)
+		if start != 0 {
+			b.WriteByte(start)
+		}
+		for j, asn := range s.ASNs {
+			if j > 0 {
+				if s.Set {
+					b.WriteByte(',')
+				} else {
+					b.WriteByte(' ')
+				}
+			}
+			b.WriteString(strconv.FormatUint(uint64(asn), 10))
+		}
+		if end != 0 {
+			b.WriteByte(end)
+		}
+	}
+
+	return b.String()
+}
+
+func segmentDelimiters(s ASPathSegment) (byte, byte) {
+	switch {
+	case s.Confed && s.Set:
+		return '[', ']'
+	case s.Confed:
+		return '(', ')'
+	case s.Set:
+		return '{', '}'
+	}
+
+	return 0, 0
+}
+
+// RIBEntry is a route to the prefix learned from a peer
+type RIBEntry struct {
+	PeerIndex      uint16
+	OriginatedTime time.Time
+	PathID         uint32
+	ASPath         ASPath
+}
+
+// RIB holds routes to a prefix
+type RIB struct {
+	Sequence uint32
+	Prefix   *net.IPNet
+	Entries  []RIBEntry
+}
+
+// Reader streams RIB records of a dump
+type Reader struct {
+	r *bufio.Reader
+	// closers release decompressor and file opened by Open, in this order
+	closers []io.Closer
+	peers   []Peer
+	header  [headerLength]byte
+	buf     []byte
+}
+
+// Open opens dump file, gzip and bzip2 compressed files are detected by their content
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("mrt: %w", err)
+	}
+
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closers = append(r.closers, f)
+
+	return r, nil
+}
+
+// NewReader constructs Reader of dump, gzip and bzip2 compressed streams are decompressed
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("mrt: %w", err)
+	}
+
+	reader := &Reader{r: br}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("mrt: %w", err)
+		}
+		reader.r = bufio.NewReader(zr)
+		reader.closers = append(reader.closers, zr)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		reader.r = bufio.NewReader(bzip2.NewReader(br))
+	}
+
+	return reader, nil
+}
+
+// Close releases the decompressor and closes the file opened by Open, the underlying
+// reader passed to NewReader is left open
+func (r *Reader) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	r.closers = nil
+
+	return first
+}
+
+// Peers returns peers of the last PEER_INDEX_TABLE read
+func (r *Reader) Peers() []Peer {
+	return r.peers
+}
+
+// Next returns next ipv4 or ipv6 unicast RIB record, io.EOF is returned at the end of the dump.
+// Records of other types are skipped.
+func (r *Reader) Next() (*RIB, error) {
+	for {
+		if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("mrt: %w", errTruncated)
+			}
+			return nil, err
+		}
+
+		typ := binary.BigEndian.Uint16(r.header[4:])
+		subtype := binary.BigEndian.Uint16(r.header[6:])
+		length := binary.BigEndian.Uint32(r.header[8:])
+		if length > maxRecordLength {
+			return nil, fmt.Errorf("mrt: record length %d exceeds limit", length)
+		}
+
+		if cap(r.buf) < int(length) {
+			r.buf = make([]byte, length)
+		}
+		body := r.buf[:length]
+		if _, err := io.ReadFull(r.r, body); err != nil {
+			return nil, fmt.Errorf("mrt: %w", errTruncated)
+		}
+
+		if typ != typeTableDumpV2 {
+			continue
+		}
+
+		var err error
+		switch subtype {
+		case subtypePeerIndexTable:
+			r.peers, err = parsePeerIndexTable(body)
+		case subtypeRIBIPv4Unicast, subtypeRIBIPv6Unicast, subtypeRIBIPv4UnicastAddPath, subtypeRIBIPv6UnicastAddPath:
+			var rib *RIB
+			ipv6 := subtype == subtypeRIBIPv6Unicast || subtype == subtypeRIBIPv6UnicastAddPath
+			addPath := subtype == subtypeRIBIPv4UnicastAddPath || subtype == subtypeRIBIPv6UnicastAddPath
+			if rib, err = parseRIB(body, ipv6, addPath); err == nil {
+				return rib, nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mrt: subtype %d: %w", subtype, err)
+		}
+	}
+}
+
+// decoder reads big endian fields, reads past the end set err and return zeros
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil || n > len(d.b) {
+		d.err = errTruncated
+		return nil
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func parsePeerIndexTable(body []byte) ([]Peer, error) {
+	d := &decoder{b: body}
+	d.uint32() // collector bgp id
+	d.bytes(int(d.uint16()))
+
+	peers := make([]Peer, d.uint16())
+	for i := range peers {
+		typ := d.uint8()
+		peers[i].BGPID = net.IP(append([]byte(nil), d.bytes(4)...))
+
+		ipLen := 4
+		if typ&0x01 != 0 {
+			ipLen = 16
+		}
+		peers[i].IP = net.IP(append([]byte(nil), d.bytes(ipLen)...))
+
+		if typ&0x02 != 0 {
+			peers[i].AS = d.uint32()
+		} else {
+			peers[i].AS = uint32(d.uint16())
+		}
+	}
+
+	return peers, d.err
+}
+
+func parseRIB(body []byte, ipv6, addPath bool) (*RIB, error) {
+	d := &decoder{b: body}
+	rib := &RIB{Sequence: d.uint32()}
+
+	bits := 32
+	if ipv6 {
+		bits = 128
+	}
+	prefixLen := int(d.uint8())
+	if prefixLen > bits {
+		return nil, fmt.Errorf("invalid prefix length %d", prefixLen)
+	}
+	ip := make(net.IP, bits/8)
+	copy(ip, d.bytes((prefixLen+7)/8))
+	mask := net.CIDRMask(prefixLen, bits)
+	rib.Prefix = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+
+	rib.Entries = make([]RIBEntry, d.uint16())
+	for i := range rib.Entries {
+		e := &rib.Entries[i]
+		e.PeerIndex = d.uint16()
+		e.OriginatedTime = time.Unix(int64(d.uint32()), 0).UTC()
+		if addPath {
+			e.PathID = d.uint32()
+		}
+
+		attrs := d.bytes(int(d.uint16()))
+		if d.err != nil {
+			break
+		}
+
+		var err error
+		if e.ASPath, err = parseAttributes(attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	return rib, d.err
+}
+
+// parseAttributes returns AS_PATH of bgp path attributes, ASNs are always 4 bytes long in TABLE_DUMP_V2
+func parseAttributes(attrs []byte) (ASPath, error) {
+	d := &decoder{b: attrs}
+	for len(d.b) > 0 && d.err == nil {
+		flags, typ := d.uint8(), d.uint8()
+
+		var length int
+		if flags&attrFlagExtendedLength != 0 {
+			length = int(d.uint16())
+		} else {
+			length = int(d.uint8())
+		}
+		value := d.bytes(length)
+
+		if typ == attrTypeASPath && d.err == nil {
+			return parseASPath(value)
+		}
+	}
+
+	return nil, d.err
+}
+
+func parseASPath(value []byte) (ASPath, error) {
+	var path ASPath
+	d := &decoder{b: value}
+	for len(d.b) > 0 && d.err == nil {
+		typ := d.uint8()
+		segment := ASPathSegment{
+			Set:    typ == segmentASSet || typ == segmentASConfedSet,
+			Confed: typ == segmentASConfedSequence || typ == segmentASConfedSet,
+		}
+		segment.ASNs = make([]uint32, d.uint8())
+		for i := range segment.ASNs {
+			segment.ASNs[i] = d.uint32()
+		}
+		path = append(path, segment)
+	}
+
+	return path, d.err
+}
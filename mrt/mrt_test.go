@@ -0,0 +1,309 @@
+package mrt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func record(typ, subtype uint16, body []byte) []byte {
+	b := make([]byte, headerLength, headerLength+len(body))
+	binary.BigEndian.PutUint32(b, 1600000000)
+	binary.BigEndian.PutUint16(b[4:], typ)
+	binary.BigEndian.PutUint16(b[6:], subtype)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(body)))
+	return append(b, body...)
+}
+
+func be16(v uint16) []byte { return []byte{byte(v >> 8), byte(v)} }
+func be32(v uint32) []byte { return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)} }
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// asPathAttr encodes ORIGIN and AS_PATH attributes, set segments are marked by leading 0
+func asPathAttr(extended bool, segments ...[]uint32) []byte {
+	var value []byte
+	for _, s := range segments {
+		typ := byte(2)
+		if s[0] == 0 {
+			typ, s = 1, s[1:]
+		}
+		value = append(value, typ, byte(len(s)))
+		for _, asn := range s {
+			value = append(value, be32(asn)...)
+		}
+	}
+
+	origin := []byte{0x40, 1, 1, 0}
+	if extended {
+		return join(origin, []byte{0x50, attrTypeASPath}, be16(uint16(len(value))), value)
+	}
+	return join(origin, []byte{0x40, attrTypeASPath, byte(len(value))}, value)
+}
+
+func ribEntry(peer uint16, pathID []byte, attrs []byte) []byte {
+	return join(be16(peer), be32(1600000000), pathID, be16(uint16(len(attrs))), attrs)
+}
+
+func rib(seq uint32, prefix []byte, entries ...[]byte) []byte {
+	return join(be32(seq), prefix, be16(uint16(len(entries))), join(entries...))
+}
+
+// testDump returns dump holding a peer table, a record of other type and a few ribs
+func testDump() []byte {
+	peers := join(
+		be32(0x0a000001), be16(4), []byte("test"), be16(2),
+		[]byte{0}, be32(0x0a000002), []byte{192, 0, 2, 1}, be16(64500),
+		[]byte{3}, be32(0x0a000003), net.ParseIP("2001:db8::1"), be32(4200000000),
+	)
+
+	return join(
+		record(typeTableDumpV2, subtypePeerIndexTable, peers),
+		record(16, 4, []byte{1, 2, 3}),
+		record(typeTableDumpV2, subtypeRIBIPv4Unicast, rib(0, []byte{8, 10},
+			ribEntry(0, nil, asPathAttr(false, []uint32{64500, 3356, 15169})),
+			ribEntry(1, nil, asPathAttr(false, []uint32{4200000000, 15169})),
+		)),
+		record(typeTableDumpV2, subtypeRIBIPv4Unicast, rib(1, []byte{16, 10, 1},
+			ribEntry(0, nil, asPathAttr(false, []uint32{64500, 64512})),
+		)),
+		record(typeTableDumpV2, subtypeRIBIPv4Unicast, rib(2, []byte{24, 192, 0, 2},
+			ribEntry(0, nil, asPathAttr(false, []uint32{64500}, []uint32{0, 65001, 65002})),
+		)),
+		record(typeTableDumpV2, subtypeRIBIPv6Unicast, rib(3, []byte{32, 0x20, 0x01, 0x0d, 0xb8},
+			ribEntry(1, nil, asPathAttr(true, []uint32{4200000000, 64496})),
+		)),
+		record(typeTableDumpV2, subtypeRIBIPv4UnicastAddPath, rib(4, []byte{23, 198, 51, 100},
+			ribEntry(0, be32(7), asPathAttr(false, []uint32{64500, 64511})),
+		)),
+	)
+}
+
+func TestReader(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(testDump())
+	w.Close()
+
+	bz, err := ioutil.ReadFile("testdata/rib.mrt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, dump := range map[string][]byte{"plain": testDump(), "gzip": gz.Bytes(), "bzip2": bz} {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(dump))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for {
+				rib, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range rib.Entries {
+					got = append(got, rib.Prefix.String()+" "+e.ASPath.String())
+				}
+			}
+
+			expected := []string{
+				"10.0.0.0/8 64500 3356 15169",
+				"10.0.0.0/8 4200000000 15169",
+				"10.1.0.0/16 64500 64512",
+				"192.0.2.0/24 64500 {65001,65002}",
+				"2001:db8::/32 4200000000 64496",
+				"198.51.100.0/23 64500 64511",
+			}
+			if len(got) != len(expected) {
+				t.Fatalf("mismatch (expected: %q, got: %q)", expected, got)
+			}
+			for i := range got {
+				if got[i] != expected[i] {
+					t.Errorf("mismatch (expected: %q, got: %q)", expected[i], got[i])
+				}
+			}
+
+			peers := r.Peers()
+			if len(peers) != 2 || peers[0].AS != 64500 || !peers[0].IP.Equal(net.IPv4(192, 0, 2, 1)) ||
+				peers[1].AS != 4200000000 || !peers[1].IP.Equal(net.ParseIP("2001:db8::1")) {
+				t.Errorf("unexpected peers %+v", peers)
+			}
+		})
+	}
+}
+
+func TestOpenGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mrt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rib.mrt.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	if _, err := w.Write(testDump()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.closers) != 2 {
+		t.Fatalf("expected closers of decompressor and file, got %d", len(r.closers))
+	}
+	file := r.closers[1].(*os.File)
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("file was not closed: %v", err)
+	}
+}
+
+func TestASPathConfed(t *testing.T) {
+	value := join(
+		[]byte{segmentASConfedSequence, 2}, be32(65010), be32(65011),
+		[]byte{segmentASConfedSet, 2}, be32(65020), be32(65021),
+		[]byte{2, 2}, be32(64500), be32(15169),
+	)
+	path, err := parseASPath(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := path.String(); s != "(65010 65011) [65020,65021] 64500 15169" {
+		t.Errorf("unexpected path %q", s)
+	}
+	if path.Len() != 2 {
+		t.Errorf("confederation segments counted in length %d", path.Len())
+	}
+	if origin, ok := path.Origin(); !ok || origin != 15169 {
+		t.Errorf("unexpected origin %d", origin)
+	}
+
+	// path of confederation segments only has no origin
+	if _, ok := path[:2].Origin(); ok {
+		t.Errorf("origin found in confederation segments")
+	}
+	trailing := append(ASPath{path[2]}, path[0])
+	if origin, ok := trailing.Origin(); !ok || origin != 15169 {
+		t.Errorf("trailing confederation segment taken as origin %d", origin)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	dump := testDump()
+	for _, n := range []int{5, len(dump) - 1} {
+		r, err := NewReader(bytes.NewReader(dump[:n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for err == nil {
+			_, err = r.Next()
+		}
+		if err == io.EOF {
+			t.Errorf("truncated dump of %d bytes was read without error", n)
+		}
+	}
+
+	// record cut within entry count
+	corrupted := record(typeTableDumpV2, subtypeRIBIPv4Unicast, rib(0, []byte{8, 10}, ribEntry(0, nil, nil))[:7])
+	r, _ := NewReader(bytes.NewReader(corrupted))
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("corrupted record was read without error")
+	}
+}
+
+func TestTable(t *testing.T) {
+	r, err := Open("testdata/rib.mrt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	table, err := ReadTable(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Len() != 5 {
+		t.Errorf("unexpected table length %d", table.Len())
+	}
+
+	testCases := []struct {
+		ip     string
+		prefix string
+		origin uint32
+		path   string
+	}{
+		{ip: "10.2.0.1", prefix: "10.0.0.0/8", origin: 15169, path: "4200000000 15169"},
+		{ip: "10.1.0.1", prefix: "10.1.0.0/16", origin: 64512, path: "64500 64512"},
+		{ip: "192.0.2.1", prefix: "192.0.2.0/24", path: "64500 {65001,65002}"},
+		{ip: "198.51.101.1", prefix: "198.51.100.0/23", origin: 64511, path: "64500 64511"},
+		{ip: "2001:db8::1", prefix: "2001:db8::/32", origin: 64496, path: "4200000000 64496"},
+		{ip: "203.0.113.1"},
+	}
+	for _, tc := range testCases {
+		route, ok := table.Lookup(net.ParseIP(tc.ip))
+		if ok != (tc.prefix != "") {
+			t.Errorf("unexpected lookup result for %s: %t", tc.ip, ok)
+			continue
+		}
+		if !ok {
+			if table.Set().Contains(net.ParseIP(tc.ip)) {
+				t.Errorf("%s is unexpectedly contained", tc.ip)
+			}
+			continue
+		}
+
+		if route.Prefix.String() != tc.prefix || route.Origin != tc.origin || route.ASPath.String() != tc.path {
+			t.Errorf("mismatch for %s (expected: %s %d %s, got: %s %d %s)", tc.ip,
+				tc.prefix, tc.origin, tc.path, route.Prefix, route.Origin, route.ASPath)
+		}
+		if !table.Set().Contains(net.ParseIP(tc.ip)) {
+			t.Errorf("%s is not contained", tc.ip)
+		}
+	}
+}
+
+func TestReadSet(t *testing.T) {
+	r, _ := NewReader(bytes.NewReader(testDump()))
+	set, err := ReadSet(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefixes := set.(interface{ Prefixes() []*net.IPNet }).Prefixes()
+	if len(prefixes) != 4 {
+		t.Errorf("unexpected prefixes %v", prefixes)
+	}
+}
@@ -0,0 +1,93 @@
+package mrt
+
+import (
+	"io"
+	"net"
+
+	"github.com/kentik/ipset"
+)
+
+// Route is the route chosen for a prefix
+type Route struct {
+	Prefix *net.IPNet
+	Origin uint32
+	ASPath ASPath
+}
+
+// Table maps announced prefixes to their routes
+type Table struct {
+	m    *ipset.Map
+	tree ipset.Tree
+}
+
+// ReadTable reads all RIB records of the dump. Of the routes learned from different peers
+// the one with the shortest AS path is kept, the first one wins ties.
+func ReadTable(r *Reader) (*Table, error) {
+	t := &Table{m: ipset.NewMap()}
+	var b ipset.Builder
+	for {
+		rib, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var best *RIBEntry
+		for i := range rib.Entries {
+			if best == nil || rib.Entries[i].ASPath.Len() < best.ASPath.Len() {
+				best = &rib.Entries[i]
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		route := &Route{Prefix: rib.Prefix, ASPath: best.ASPath}
+		route.Origin, _ = best.ASPath.Origin()
+		t.m.Insert(rib.Prefix, route)
+		b.Add(rib.Prefix)
+	}
+	t.tree = b.Build()
+
+	return t, nil
+}
+
+// ReadSet reads all prefixes announced in the dump, skipping their paths
+func ReadSet(r *Reader) (ipset.Set, error) {
+	var b ipset.Builder
+	for {
+		rib, err := r.Next()
+		if err == io.EOF {
+			return b.Build(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rib.Entries) > 0 {
+			b.Add(rib.Prefix)
+		}
+	}
+}
+
+// Lookup returns route of the longest announced prefix containing ip
+func (t *Table) Lookup(ip net.IP) (*Route, bool) {
+	v, _, ok := t.m.Lookup(ip)
+	if !ok {
+		return nil, false
+	}
+
+	return v.(*Route), true
+}
+
+// Len returns number of announced prefixes
+func (t *Table) Len() int {
+	return t.m.Len()
+}
+
+// Set returns set of announced prefixes
+func (t *Table) Set() ipset.Set {
+	return t.tree
+}
@@ -0,0 +1,169 @@
+package ipset
+
+import (
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// First returns the lowest address covered by the set, ipv6 keys below ipv4 space come first
+func First(t Tree) (net.IP, bool) {
+	return ipFromAddr(treeOf(t).firstInRange(uint128.Zero, uint128.Max))
+}
+
+// Last returns the highest address covered by the set
+func Last(t Tree) (net.IP, bool) {
+	return ipFromAddr(treeOf(t).lastInRange(uint128.Zero, uint128.Max))
+}
+
+// NextContained returns the lowest covered address past ip, it never leaves ip family
+func NextContained(t Tree, ip net.IP) (net.IP, bool) {
+	s := treeOf(t)
+	var res uint128.Uint128
+	found := s.forward(ip, func(start, end uint128.Uint128) bool {
+		addr, ok := s.firstInRange(start, end)
+		res = addr
+		return ok
+	})
+
+	return ipFromAddr(res, found)
+}
+
+// PrevContained returns the highest covered address before ip, it never leaves ip family
+func PrevContained(t Tree, ip net.IP) (net.IP, bool) {
+	s := treeOf(t)
+	var res uint128.Uint128
+	found := s.backward(ip, func(start, end uint128.Uint128) bool {
+		addr, ok := s.lastInRange(start, end)
+		res = addr
+		return ok
+	})
+
+	return ipFromAddr(res, found)
+}
+
+// NextNotContained returns the lowest address past ip not covered by the set, it never leaves ip family
+func NextNotContained(t Tree, ip net.IP) (net.IP, bool) {
+	s := treeOf(t)
+	var res uint128.Uint128
+	found := s.forward(ip, func(start, end uint128.Uint128) bool {
+		ok := false
+		s.gaps(start, end, func(start, _ uint128.Uint128) bool {
+			res, ok = start, true
+			return false
+		})
+		return ok
+	})
+
+	return ipFromAddr(res, found)
+}
+
+// forward calls fn with ranges of ip family past ip in ascending order until fn returns true
+func (s *ipset) forward(ip net.IP, fn func(start, end uint128.Uint128) bool) bool {
+	addr, err := uint128FromIP(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, r := range familyRanges(addr) {
+		if addr.Cmp(r[1]) >= 0 {
+			continue
+		}
+		if addr.Cmp(r[0]) >= 0 {
+			r[0] = addr.Add64(1)
+		}
+		if fn(r[0], r[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backward calls fn with ranges of ip family before ip in descending order until fn returns true
+func (s *ipset) backward(ip net.IP, fn func(start, end uint128.Uint128) bool) bool {
+	addr, err := uint128FromIP(ip)
+	if err != nil {
+		return false
+	}
+
+	ranges := familyRanges(addr)
+	for i := len(ranges) - 1; i >= 0; i-- {
+		r := ranges[i]
+		if addr.Cmp(r[0]) <= 0 {
+			continue
+		}
+		if addr.Cmp(r[1]) <= 0 {
+			r[1] = addr.Sub64(1)
+		}
+		if fn(r[0], r[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// familyRanges returns key ranges of addr family, ipv6 ranges exclude ipv4 space
+func familyRanges(addr uint128.Uint128) [][2]uint128.Uint128 {
+	if matchingPrefix(addr, ipv4Space) >= 96 {
+		return [][2]uint128.Uint128{{ipv4Space, ipv4Space.Or(hostMask(96))}}
+	}
+
+	return universeRanges(treeNode{})
+}
+
+// firstInRange returns the lowest covered address in [start, end]
+func (s *ipset) firstInRange(start, end uint128.Uint128) (uint128.Uint128, bool) {
+	var res uint128.Uint128
+	found := false
+	s.walkRange(start, end, func(addr uint128.Uint128, _ uint32) bool {
+		res, found = addr, true
+		if addr.Cmp(start) < 0 {
+			res = start
+		}
+		return false
+	})
+
+	return res, found
+}
+
+// lastInRange returns the highest covered address in [start, end], descending right children first
+func (s *ipset) lastInRange(start, end uint128.Uint128) (uint128.Uint128, bool) {
+	var visit func(i, offset uint32) (uint128.Uint128, bool)
+	visit = func(i, offset uint32) (uint128.Uint128, bool) {
+		n := &s.nodes[i]
+		offset += n.prefix
+		first := maskAddr(n.addr, offset)
+		last := first.Or(hostMask(offset))
+		if first.Cmp(end) > 0 || last.Cmp(start) < 0 {
+			return uint128.Zero, false
+		}
+
+		if n.left == 0 {
+			if last.Cmp(end) > 0 {
+				last = end
+			}
+			return last, true
+		}
+
+		if addr, ok := visit(n.right, offset); ok {
+			return addr, true
+		}
+		return visit(n.left, offset)
+	}
+
+	if s.root == 0 {
+		return uint128.Zero, false
+	}
+	return visit(s.root, 0)
+}
+
+// ipFromAddr converts found key into address, ipv4 mapped keys are returned as ipv4 addresses
+func ipFromAddr(addr uint128.Uint128, found bool) (net.IP, bool) {
+	if !found {
+		return nil, false
+	}
+
+	return netFromAddr(addr, 128).IP, true
+}
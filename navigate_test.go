@@ -0,0 +1,141 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestFirstLast(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		cidrs []*net.IPNet
+		first string
+		last  string
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc:  "ipv4",
+			cidrs: parseCidrs("10.0.0.0/8", "192.168.1.0/24"),
+			first: "10.0.0.0",
+			last:  "192.168.1.255",
+		},
+		{
+			desc:  "both families",
+			cidrs: parseCidrs("10.0.0.0/8", "2001:db8::/32", "::/120"),
+			first: "::",
+			last:  "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			set := NewTree(tc.cidrs...)
+			first, ok := First(set)
+			if ok != (tc.first != "") || ok && first.String() != tc.first {
+				t.Errorf("first mismatch (expected: %q, got: %v %t)", tc.first, first, ok)
+			}
+			last, ok := Last(set)
+			if ok != (tc.last != "") || ok && last.String() != tc.last {
+				t.Errorf("last mismatch (expected: %q, got: %v %t)", tc.last, last, ok)
+			}
+		})
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	set := NewTree(parseCidrs("10.0.0.0/24", "10.0.1.0/25", "10.0.2.0/32", "255.255.255.0/24", "::/127", "2001:db8::/64")...)
+	testCases := []struct {
+		ip               string
+		nextContained    string
+		prevContained    string
+		nextNotContained string
+	}{
+		{ip: "0.0.0.0", nextContained: "10.0.0.0", nextNotContained: "0.0.0.1"},
+		{ip: "10.0.0.5", nextContained: "10.0.0.6", prevContained: "10.0.0.4", nextNotContained: "10.0.1.128"},
+		{ip: "10.0.0.200", nextContained: "10.0.0.201", prevContained: "10.0.0.199", nextNotContained: "10.0.1.128"},
+		{ip: "10.0.1.127", nextContained: "10.0.2.0", prevContained: "10.0.1.126", nextNotContained: "10.0.1.128"},
+		{ip: "10.0.1.200", nextContained: "10.0.2.0", prevContained: "10.0.1.127", nextNotContained: "10.0.1.201"},
+		{ip: "10.0.2.0", nextContained: "255.255.255.0", prevContained: "10.0.1.127", nextNotContained: "10.0.2.1"},
+		{ip: "255.255.255.1", nextContained: "255.255.255.2", prevContained: "255.255.255.0"},
+		{ip: "255.255.255.255", prevContained: "255.255.255.254"},
+		{ip: "::", nextContained: "::1", nextNotContained: "::2"},
+		{ip: "::1", nextContained: "2001:db8::", prevContained: "::", nextNotContained: "::2"},
+		{ip: "::fffe:ffff:ffff", nextContained: "2001:db8::", prevContained: "::1", nextNotContained: "::1:0:0:0"},
+		{ip: "1::", nextContained: "2001:db8::", prevContained: "::1", nextNotContained: "1::1"},
+		{ip: "2001:db8::ffff", nextContained: "2001:db8::1:0", prevContained: "2001:db8::fffe", nextNotContained: "2001:db8:0:1::"},
+		{ip: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", prevContained: "2001:db8::ffff:ffff:ffff:ffff"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			ip := net.ParseIP(tc.ip)
+			for _, c := range []struct {
+				name     string
+				fn       func(Tree, net.IP) (net.IP, bool)
+				expected string
+			}{
+				{"NextContained", NextContained, tc.nextContained},
+				{"PrevContained", PrevContained, tc.prevContained},
+				{"NextNotContained", NextNotContained, tc.nextNotContained},
+			} {
+				got, ok := c.fn(set, ip)
+				if ok != (c.expected != "") || ok && got.String() != c.expected {
+					t.Errorf("%s mismatch (expected: %q, got: %v %t)", c.name, c.expected, got, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestNavigationRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// small space so brute force stays cheap
+	var cidrs []*net.IPNet
+	for _, cidr := range randomIPv4Cidrs(rng, 200) {
+		ones, _ := cidr.Mask.Size()
+		if ones < 20 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, 0x0a000000|binary.BigEndian.Uint32(cidr.IP.To4())&0xffff)
+		cidrs = append(cidrs, &net.IPNet{IP: ip, Mask: cidr.Mask})
+	}
+	set := NewTree(cidrs...)
+
+	ip := func(i uint32) net.IP {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, 0x0a000000|i)
+		return ip
+	}
+	for n := 0; n < 200; n++ {
+		i := uint32(rng.Intn(1 << 16))
+
+		var next, prev, nextNot net.IP
+		for j := i + 1; j < 1<<16 && (next == nil || nextNot == nil); j++ {
+			contained := set.Contains(ip(j))
+			if contained && next == nil {
+				next = ip(j)
+			}
+			if !contained && nextNot == nil {
+				nextNot = ip(j)
+			}
+		}
+		for j := int(i) - 1; j >= 0 && prev == nil; j-- {
+			if set.Contains(ip(uint32(j))) {
+				prev = ip(uint32(j))
+			}
+		}
+
+		if got, _ := NextContained(set, ip(i)); next != nil && !got.Equal(next) {
+			t.Fatalf("NextContained(%s) mismatch (expected: %s, got: %s)", ip(i), next, got)
+		}
+		if got, _ := PrevContained(set, ip(i)); prev != nil && !got.Equal(prev) {
+			t.Fatalf("PrevContained(%s) mismatch (expected: %s, got: %s)", ip(i), prev, got)
+		}
+		if got, _ := NextNotContained(set, ip(i)); nextNot != nil && !got.Equal(nextNot) {
+			t.Fatalf("NextNotContained(%s) mismatch (expected: %s, got: %s)", ip(i), nextNot, got)
+		}
+	}
+}
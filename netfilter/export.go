@@ -0,0 +1,157 @@
+// Package netfilter renders sets as input of Linux ipset, nftables and iptables tools and reads
+// sets back from their output.
+package netfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/kentik/ipset"
+)
+
+// Family selects addresses of one family, kernel sets and chains hold only one of them
+type Family int
+
+// Address families
+const (
+	IPv4 Family = 4
+	IPv6 Family = 6
+)
+
+// prefixes returns minimal list of prefixes of s of given family, see ipset.PrefixesByFamily
+func prefixes(s ipset.Set, family Family) ([]*net.IPNet, error) {
+	ipv4, ipv6, err := ipset.PrefixesByFamily(s)
+	if err != nil {
+		return nil, fmt.Errorf("netfilter: %w", err)
+	}
+	if family == IPv4 {
+		return ipv4, nil
+	}
+
+	return ipv6, nil
+}
+
+func familyName(family Family) (string, error) {
+	switch family {
+	case IPv4:
+		return "inet", nil
+	case IPv6:
+		return "inet6", nil
+	}
+
+	return "", fmt.Errorf("netfilter: unsupported family %d", family)
+}
+
+// WriteIPSetRestore writes prefixes of s of given family as `ipset restore` input creating
+// hash:net set of given name, the set is flushed first so restoring replaces its content
+func WriteIPSetRestore(w io.Writer, s ipset.Set, name string, family Family) error {
+	inet, err := familyName(family)
+	if err != nil {
+		return err
+	}
+	list, err := prefixes(s, family)
+	if err != nil {
+		return err
+	}
+
+	// hash:net does not take zero length prefixes, the whole space is stored as its halves
+	if len(list) == 1 {
+		if ones, bits := list[0].Mask.Size(); ones == 0 {
+			list = []*net.IPNet{
+				{IP: make(net.IP, bits/8), Mask: net.CIDRMask(1, bits)},
+				{IP: append(net.IP{0x80}, make(net.IP, bits/8-1)...), Mask: net.CIDRMask(1, bits)},
+			}
+		}
+	}
+
+	maxElem := 65536
+	if len(list) > maxElem {
+		maxElem = len(list)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "create %s hash:net family %s hashsize 1024 maxelem %d -exist\n", name, inet, maxElem)
+	fmt.Fprintf(bw, "flush %s\n", name)
+	for _, p := range list {
+		fmt.Fprintf(bw, "add %s %s\n", name, p)
+	}
+
+	return bw.Flush()
+}
+
+// WriteNftSet writes prefixes of s of given family as nftables interval set block, to be placed in a table
+func WriteNftSet(w io.Writer, s ipset.Set, name string, family Family) error {
+	typ := "ipv4_addr"
+	if family == IPv6 {
+		typ = "ipv6_addr"
+	} else if family != IPv4 {
+		return fmt.Errorf("netfilter: unsupported family %d", family)
+	}
+	list, err := prefixes(s, family)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "set %s {\n\ttype %s\n\tflags interval\n", name, typ)
+	// nft rejects empty element list
+	if len(list) > 0 {
+		fmt.Fprintf(bw, "\telements = {\n")
+		for i, p := range list {
+			sep := ","
+			if i == len(list)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(bw, "\t\t%s%s\n", p, sep)
+		}
+		fmt.Fprintf(bw, "\t}\n")
+	}
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
+
+// Chain describes iptables chain matching prefixes of a set
+type Chain struct {
+	// Table is filter by default
+	Table string
+	Name  string
+	// Target is DROP by default
+	Target string
+	// Destination matches destination addresses instead of source ones
+	Destination bool
+}
+
+// WriteIptablesRestore writes prefixes of s of given family as `iptables-restore` or `ip6tables-restore` input,
+// declaring the chain flushes it so restoring replaces its rules
+func WriteIptablesRestore(w io.Writer, s ipset.Set, c Chain, family Family) error {
+	if _, err := familyName(family); err != nil {
+		return err
+	}
+	list, err := prefixes(s, family)
+	if err != nil {
+		return err
+	}
+
+	table, target, match := c.Table, c.Target, "-s"
+	if table == "" {
+		table = "filter"
+	}
+	if target == "" {
+		target = "DROP"
+	}
+	if c.Destination {
+		match = "-d"
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "*%s\n:%s - [0:0]\n", table, c.Name)
+	for _, p := range list {
+		fmt.Fprintf(bw, "-A %s %s %s -j %s\n", c.Name, match, p, target)
+	}
+	fmt.Fprintf(bw, "COMMIT\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,130 @@
+package netfilter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/kentik/ipset"
+)
+
+func parseCidrs(s ...string) []*net.IPNet {
+	res := make([]*net.IPNet, len(s))
+	for i, ss := range s {
+		_, cidr, err := net.ParseCIDR(ss)
+		if err != nil {
+			panic(err)
+		}
+		res[i] = cidr
+	}
+
+	return res
+}
+
+func testSet() ipset.Set {
+	return ipset.NewSet(parseCidrs("10.0.0.0/25", "10.0.0.128/25", "192.168.1.0/24", "192.168.1.7/32", "2001:db8::/32")...)
+}
+
+func TestWriteIPSetRestore(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		set      ipset.Set
+		family   Family
+		expected string
+	}{
+		{
+			desc:   "ipv4",
+			set:    testSet(),
+			family: IPv4,
+			expected: "create blocked hash:net family inet hashsize 1024 maxelem 65536 -exist\n" +
+				"flush blocked\n" +
+				"add blocked 10.0.0.0/24\n" +
+				"add blocked 192.168.1.0/24\n",
+		},
+		{
+			desc:   "ipv6",
+			set:    testSet(),
+			family: IPv6,
+			expected: "create blocked hash:net family inet6 hashsize 1024 maxelem 65536 -exist\n" +
+				"flush blocked\n" +
+				"add blocked 2001:db8::/32\n",
+		},
+		{
+			desc:   "whole ipv4 space covered by ipv6 prefix",
+			set:    ipset.NewSet(parseCidrs("::/0")...),
+			family: IPv4,
+			expected: "create blocked hash:net family inet hashsize 1024 maxelem 65536 -exist\n" +
+				"flush blocked\n" +
+				"add blocked 0.0.0.0/1\n" +
+				"add blocked 128.0.0.0/1\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteIPSetRestore(&buf, tc.set, "blocked", tc.family); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.expected {
+				t.Errorf("mismatch (expected: %q, got: %q)", tc.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteNftSet(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteNftSet(&buf, testSet(), "blocked", IPv4); err != nil {
+		t.Fatal(err)
+	}
+	expected := "set blocked {\n\ttype ipv4_addr\n\tflags interval\n\telements = {\n\t\t10.0.0.0/24,\n\t\t192.168.1.0/24\n\t}\n}\n"
+	if buf.String() != expected {
+		t.Errorf("mismatch (expected: %q, got: %q)", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteNftSet(&buf, ipset.NewSet(parseCidrs("10.0.0.0/8")...), "empty", IPv6); err != nil {
+		t.Fatal(err)
+	}
+	expected = "set empty {\n\ttype ipv6_addr\n\tflags interval\n}\n"
+	if buf.String() != expected {
+		t.Errorf("mismatch (expected: %q, got: %q)", expected, buf.String())
+	}
+}
+
+func TestWriteIptablesRestore(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteIptablesRestore(&buf, testSet(), Chain{Name: "BLOCKED"}, IPv4); err != nil {
+		t.Fatal(err)
+	}
+	expected := "*filter\n:BLOCKED - [0:0]\n" +
+		"-A BLOCKED -s 10.0.0.0/24 -j DROP\n" +
+		"-A BLOCKED -s 192.168.1.0/24 -j DROP\n" +
+		"COMMIT\n"
+	if buf.String() != expected {
+		t.Errorf("mismatch (expected: %q, got: %q)", expected, buf.String())
+	}
+
+	buf.Reset()
+	c := Chain{Table: "raw", Name: "BLOCKED6", Target: "REJECT", Destination: true}
+	if err := WriteIptablesRestore(&buf, testSet(), c, IPv6); err != nil {
+		t.Fatal(err)
+	}
+	expected = "*raw\n:BLOCKED6 - [0:0]\n-A BLOCKED6 -d 2001:db8::/32 -j REJECT\nCOMMIT\n"
+	if buf.String() != expected {
+		t.Errorf("mismatch (expected: %q, got: %q)", expected, buf.String())
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	r, err := ipset.NewRangeSet(testSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteIPSetRestore(&bytes.Buffer{}, r, "blocked", IPv4); err == nil {
+		t.Errorf("set without prefixes was written")
+	}
+	if err := WriteNftSet(&bytes.Buffer{}, testSet(), "blocked", Family(5)); err == nil {
+		t.Errorf("unknown family was written")
+	}
+}
@@ -0,0 +1,263 @@
+package netfilter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/kentik/ipset"
+)
+
+// ParseIPSetSave reads sets of `ipset save` output. Only hash:net, hash:ip and bitmap:ip sets are read,
+// entries marked nomatch are excluded from their sets.
+func ParseIPSetSave(r io.Reader) (map[string]ipset.Tree, error) {
+	sets := make(map[string]ipset.Tree)
+	skipped := make(map[string]bool)
+	excluded := make(map[string][]*net.IPNet)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "create" && fields[0] != "add" {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("netfilter: line %d: expected at least 3 fields, got %d", line, len(fields))
+		}
+
+		name := fields[1]
+		switch fields[0] {
+		case "create":
+			switch fields[2] {
+			case "hash:net", "hash:ip", "bitmap:ip":
+				sets[name] = ipset.NewTree()
+			default:
+				skipped[name] = true
+			}
+		case "add":
+			if skipped[name] {
+				continue
+			}
+			set, ok := sets[name]
+			if !ok {
+				return nil, fmt.Errorf("netfilter: line %d: add to unknown set %s", line, name)
+			}
+
+			cidrs, err := parseElement(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("netfilter: line %d: %w", line, err)
+			}
+			if hasOption(fields[3:], "nomatch") {
+				excluded[name] = append(excluded[name], cidrs...)
+				continue
+			}
+			for _, cidr := range cidrs {
+				set.Add(cidr)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("netfilter: %w", err)
+	}
+
+	for name, cidrs := range excluded {
+		for _, cidr := range cidrs {
+			sets[name].Remove(cidr)
+		}
+	}
+
+	return sets, nil
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseElement parses address, prefix or range of addresses written as first-last
+func parseElement(element string) ([]*net.IPNet, error) {
+	if i := strings.IndexByte(element, '-'); i >= 0 {
+		first, last := net.ParseIP(element[:i]), net.ParseIP(element[i+1:])
+		if first == nil || last == nil {
+			return nil, fmt.Errorf("invalid range %q", element)
+		}
+		return ipset.RangeToCIDRs(first, last)
+	}
+
+	if strings.IndexByte(element, '/') >= 0 {
+		_, cidr, err := net.ParseCIDR(element)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.IPNet{cidr}, nil
+	}
+
+	ip := net.ParseIP(element)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", element)
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return []*net.IPNet{{IP: ipv4, Mask: net.CIDRMask(32, 32)}}, nil
+	}
+
+	return []*net.IPNet{{IP: ip, Mask: net.CIDRMask(128, 128)}}, nil
+}
+
+// ParseNftSets reads address sets of `nft list set`, `nft list sets` or `nft list ruleset` output,
+// sets of other types are skipped
+func ParseNftSets(r io.Reader) (map[string]ipset.Tree, error) {
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("netfilter: %w", err)
+	}
+
+	p := &nftParser{tokens: nftTokens(string(text))}
+	sets := make(map[string]ipset.Tree)
+	for !p.done() {
+		// set definitions start with set keyword followed by name and brace, the same words
+		// may appear in rules, like in "ip saddr @set"
+		if p.peek(0) != "set" || p.peek(2) != "{" {
+			p.next()
+			continue
+		}
+		p.next()
+		name := p.next()
+		p.next()
+
+		set, err := p.set()
+		if err != nil {
+			return nil, fmt.Errorf("netfilter: set %s: %w", name, err)
+		}
+		if set != nil {
+			sets[name] = set
+		}
+	}
+
+	return sets, nil
+}
+
+type nftParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *nftParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *nftParser) peek(i int) string {
+	if p.pos+i >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos+i]
+}
+
+func (p *nftParser) next() string {
+	token := p.peek(0)
+	p.pos++
+
+	return token
+}
+
+// set parses body of set following its opening brace, returns nil for sets not holding addresses
+func (p *nftParser) set() (ipset.Tree, error) {
+	addresses := false
+	var elements []string
+	for {
+		switch token := p.next(); token {
+		case "":
+			return nil, errors.New("unexpected end of input")
+		case "}":
+			if !addresses {
+				return nil, nil
+			}
+
+			set := ipset.NewTree()
+			for _, element := range elements {
+				cidrs, err := parseElement(element)
+				if err != nil {
+					return nil, err
+				}
+				for _, cidr := range cidrs {
+					set.Add(cidr)
+				}
+			}
+			return set, nil
+		case "type":
+			typ := p.next()
+			addresses = typ == "ipv4_addr" || typ == "ipv6_addr"
+		case "typeof":
+			// like typeof ip saddr
+			family, field := p.next(), p.next()
+			addresses = (family == "ip" || family == "ip6") && (field == "saddr" || field == "daddr")
+		case "elements":
+			if p.next() != "=" || p.next() != "{" {
+				return nil, errors.New("malformed elements")
+			}
+
+			// first token of every comma separated group is the element, others are its attributes
+			first := true
+			for {
+				token := p.next()
+				if token == "" {
+					return nil, errors.New("unexpected end of input")
+				}
+				if token == "}" {
+					break
+				}
+				if token == "," {
+					first = true
+					continue
+				}
+				if first {
+					elements = append(elements, token)
+					first = false
+				}
+			}
+		}
+	}
+}
+
+// nftTokens splits nft output into words, quoted strings and punctuation, comments are dropped
+func nftTokens(text string) []string {
+	var tokens []string
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ',' || c == '=' || c == ';':
+			tokens = append(tokens, text[i:i+1])
+			i++
+		case c == '"':
+			end := len(text)
+			if j := strings.IndexByte(text[i+1:], '"'); j >= 0 {
+				end = i + j + 2
+			}
+			tokens = append(tokens, text[i:end])
+			i = end
+		default:
+			start := i
+			for i < len(text) && !strings.ContainsRune(" \t\r\n#{},=;\"", rune(text[i])) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,141 @@
+package netfilter
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kentik/ipset"
+)
+
+func prefixStrings(l ipset.Tree) []string {
+	var res []string
+	for _, p := range l.Prefixes() {
+		res = append(res, p.String())
+	}
+
+	return res
+}
+
+func TestParseIPSetSave(t *testing.T) {
+	save := `create blocked hash:net family inet hashsize 1024 maxelem 65536
+add blocked 10.0.0.0/25
+add blocked 10.0.0.128/25
+add blocked 10.0.0.64/26 nomatch
+add blocked 192.168.1.1 timeout 0 comment "some host"
+create hosts6 hash:ip family inet6 hashsize 1024 maxelem 65536
+add hosts6 2001:db8::1
+create ports bitmap:port range 0-1024
+add ports 80
+create range bitmap:ip range 10.1.0.0-10.1.255.255
+add range 10.1.0.1-10.1.0.6
+`
+	sets, err := ParseIPSetSave(strings.NewReader(save))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"blocked": {"10.0.0.0/26", "10.0.0.128/25", "192.168.1.1/32"},
+		"hosts6":  {"2001:db8::1/128"},
+		"range":   {"10.1.0.1/32", "10.1.0.2/31", "10.1.0.4/31", "10.1.0.6/32"},
+	}
+	if len(sets) != len(expected) {
+		t.Errorf("unexpected sets %v", sets)
+	}
+	for name, prefixes := range expected {
+		if got := prefixStrings(sets[name]); !reflect.DeepEqual(got, prefixes) {
+			t.Errorf("mismatch for %s (expected: %v, got: %v)", name, prefixes, got)
+		}
+	}
+
+	if _, err := ParseIPSetSave(strings.NewReader("add missing 10.0.0.0/8\n")); err == nil {
+		t.Errorf("add to unknown set was accepted")
+	}
+	if _, err := ParseIPSetSave(strings.NewReader("create s hash:net\nadd s 10.0.0.0/33\n")); err == nil {
+		t.Errorf("invalid prefix was accepted")
+	}
+}
+
+func TestParseNftSets(t *testing.T) {
+	ruleset := `table inet filter {
+	set blocked {
+		type ipv4_addr
+		flags interval
+		auto-merge
+		elements = { 10.0.0.0/8, 192.168.1.1 timeout 1h expires 59m comment "one, two",
+			     192.168.2.0-192.168.2.9 }
+	}
+
+	set blocked6 {
+		typeof ip6 saddr
+		flags interval
+		elements = { 2001:db8::/32 }
+	}
+
+	set ports {
+		type inet_service
+		elements = { 22, 80 }
+	}
+
+	set empty { type ipv4_addr; flags interval; }
+
+	chain input {
+		type filter hook input priority filter; policy accept;
+		ip saddr @blocked drop # drop blocked
+	}
+}
+`
+	sets, err := ParseNftSets(strings.NewReader(ruleset))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"blocked":  {"10.0.0.0/8", "192.168.1.1/32", "192.168.2.0/29", "192.168.2.8/31"},
+		"blocked6": {"2001:db8::/32"},
+		"empty":    nil,
+	}
+	if len(sets) != len(expected) {
+		t.Errorf("unexpected sets %v", sets)
+	}
+	for name, prefixes := range expected {
+		if got := prefixStrings(sets[name]); !reflect.DeepEqual(got, prefixes) {
+			t.Errorf("mismatch for %s (expected: %v, got: %v)", name, prefixes, got)
+		}
+	}
+
+	if _, err := ParseNftSets(strings.NewReader("set s { type ipv4_addr; elements = { 10.0.0.0/8")); err == nil {
+		t.Errorf("truncated set was accepted")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, family := range []Family{IPv4, IPv6} {
+		var buf bytes.Buffer
+		if err := WriteIPSetRestore(&buf, testSet(), "s", family); err != nil {
+			t.Fatal(err)
+		}
+		sets, err := ParseIPSetSave(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf.Reset()
+		if err := WriteNftSet(&buf, testSet(), "s", family); err != nil {
+			t.Fatal(err)
+		}
+		nftSets, err := ParseNftSets(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, _ := prefixes(testSet(), family)
+		for _, set := range []ipset.Tree{sets["s"], nftSets["s"]} {
+			if got := set.Prefixes(); !reflect.DeepEqual(got, expected) {
+				t.Errorf("mismatch for family %d (expected: %v, got: %v)", family, expected, got)
+			}
+		}
+	}
+}
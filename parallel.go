@@ -0,0 +1,282 @@
+package ipset
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"lukechampine.com/uint128"
+)
+
+// NewTreeParallel constructs the same Tree as NewTree, using given number of goroutines.
+// Prefixes are partitioned into shards by top bits of the key, following bits shared by all of them,
+// shards are built independently and stitched under a common root. Ipv4 mapped keys share the same 96 bits
+// so they are sharded separately from the rest.
+func NewTreeParallel(cidrs []*net.IPNet, workers int) Tree {
+	if workers < 1 {
+		workers = 1
+	}
+
+	leaves := parseParallel(cidrs, workers)
+
+	// shard count per family, a few per worker to even out their sizes
+	shardBits := uint32(2)
+	for 1<<(shardBits-2) < workers && shardBits < 8 {
+		shardBits++
+	}
+
+	var ipv4, other shardFamily
+	for i := range leaves {
+		if isIPv4Node(&leaves[i]) {
+			ipv4.observe(&leaves[i])
+		} else {
+			other.observe(&leaves[i])
+			if leaves[i].prefix <= 96 && matchingPrefix(leaves[i].addr, ipv4Space) >= leaves[i].prefix {
+				ipv4.covered = true
+			}
+		}
+	}
+	ipv4.init(shardBits)
+	other.init(shardBits)
+
+	for i := range leaves {
+		if isIPv4Node(&leaves[i]) {
+			ipv4.add(leaves[i])
+		} else {
+			other.add(leaves[i])
+		}
+	}
+
+	shards := append(ipv4.shards, other.shards...)
+	trees := make([]*ipset, len(shards))
+
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				trees[i] = buildShard(shards[i])
+			}
+		}()
+	}
+	for i := range shards {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return stitchShards(trees[:len(ipv4.shards)], trees[len(ipv4.shards):])
+}
+
+// parseParallel converts cidrs into leaves, panics on invalid cidr as Add does
+func parseParallel(cidrs []*net.IPNet, workers int) []treeNode {
+	leaves := make([]treeNode, len(cidrs))
+	errs := make([]error, workers)
+	chunk := (len(cidrs) + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * chunk; i < (w+1)*chunk && i < len(cidrs); i++ {
+				leaf, err := leafFromNet(cidrs[i])
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				leaves[i] = leaf
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return leaves
+}
+
+func isIPv4Node(n *treeNode) bool {
+	return n.prefix >= 96 && matchingPrefix(n.addr, ipv4Space) >= 96
+}
+
+// shardFamily partitions prefixes by bits following the ones shared by all of them
+type shardFamily struct {
+	count     int
+	min, max  uint128.Uint128
+	minPrefix uint32
+	// covered is set when whole family is enclosed by prefix from other one
+	covered bool
+
+	offset uint32
+	bits   uint32
+	shards [][]treeNode
+}
+
+func (f *shardFamily) observe(n *treeNode) {
+	if f.count == 0 || n.addr.Cmp(f.min) < 0 {
+		f.min = n.addr
+	}
+	if f.count == 0 || n.addr.Cmp(f.max) > 0 {
+		f.max = n.addr
+	}
+	if f.count == 0 || n.prefix < f.minPrefix {
+		f.minPrefix = n.prefix
+	}
+	f.count++
+}
+
+func (f *shardFamily) init(bits uint32) {
+	if f.count == 0 || f.covered {
+		return
+	}
+
+	f.offset = matchingPrefix(f.min, f.max)
+	if f.minPrefix < f.offset {
+		f.offset = f.minPrefix
+	}
+
+	f.bits = bits
+	if f.offset+f.bits > 128 {
+		f.bits = 128 - f.offset
+	}
+	f.shards = make([][]treeNode, 1<<f.bits)
+}
+
+// add puts node into its shard, nodes spanning more than one shard are split into shard sized blocks
+func (f *shardFamily) add(n treeNode) {
+	if f.covered {
+		return
+	}
+
+	depth := f.offset + f.bits
+	shard := int(n.addr.Rsh(uint(128 - depth)).And64(1<<f.bits - 1).Lo)
+	if n.prefix >= depth {
+		f.shards[shard] = append(f.shards[shard], n)
+		return
+	}
+
+	for i := 0; i < 1<<(depth-n.prefix); i++ {
+		block := treeNode{addr: n.addr.Or(uint128.From64(uint64(i)).Lsh(uint(128 - depth))), prefix: depth}
+		f.shards[shard+i] = append(f.shards[shard+i], block)
+	}
+}
+
+func buildShard(leaves []treeNode) *ipset {
+	s := &ipset{}
+	if len(leaves) == 0 {
+		return s
+	}
+
+	sortNodes(leaves, 0)
+	leaves = aggregate(leaves)
+	s.nodes = make([]treeNode, 1, 2*len(leaves))
+	s.root = s.build(leaves, 0)
+
+	return s
+}
+
+// subtree is a root of shard tree moved into the final one
+type subtree struct {
+	addr  uint128.Uint128
+	depth uint32
+	index uint32
+}
+
+// stitchShards moves nodes of shard trees into single slice and links their roots,
+// ipv4 subtree may fall between prefixes of the other family so it is inserted into their tree afterwards
+func stitchShards(ipv4, other []*ipset) *ipset {
+	size := 1
+	for _, trees := range [][]*ipset{ipv4, other} {
+		for _, t := range trees {
+			if t.root != 0 {
+				size += len(t.nodes) - 1
+			}
+		}
+	}
+
+	s := &ipset{nodes: make([]treeNode, 1, size)}
+	ipv4Root := s.stitch(s.move(ipv4), 0)
+	s.root = s.stitch(s.move(other), 0)
+
+	if ipv4Root != 0 {
+		node := s.nodes[ipv4Root]
+		s.nodes[ipv4Root] = treeNode{}
+		s.free = append(s.free, ipv4Root)
+		s.add(node)
+	}
+
+	return s
+}
+
+// move appends nodes of trees to the slice, returns their roots sorted by address
+func (s *ipset) move(trees []*ipset) []subtree {
+	var roots []subtree
+	for _, t := range trees {
+		if t.root == 0 {
+			continue
+		}
+
+		base := uint32(len(s.nodes) - 1)
+		for _, n := range t.nodes[1:] {
+			if n.left != 0 {
+				n.left += base
+				n.right += base
+			}
+			s.nodes = append(s.nodes, n)
+		}
+		for _, i := range t.free {
+			s.free = append(s.free, i+base)
+		}
+
+		root := &t.nodes[t.root]
+		roots = append(roots, subtree{addr: root.addr, depth: root.prefix, index: t.root + base})
+	}
+
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].addr.Cmp(roots[j].addr) < 0
+	})
+
+	return roots
+}
+
+// stitch links sorted disjoint subtrees into one hanging at offset, it is build working on whole subtrees
+// which also merges them when shards turn out to be complementing blocks
+func (s *ipset) stitch(roots []subtree, offset uint32) uint32 {
+	if len(roots) == 0 {
+		return 0
+	}
+
+	if len(roots) == 1 {
+		s.nodes[roots[0].index].prefix = roots[0].depth - offset
+		return roots[0].index
+	}
+
+	first, last := roots[0].addr, roots[len(roots)-1].addr
+	matching := matchingPrefix(first, last)
+	split := sort.Search(len(roots), func(i int) bool {
+		return roots[i].addr.Rsh(uint(128-(matching+1))).And64(0x01) != uint128.Zero
+	})
+
+	left := s.stitch(roots[:split], matching)
+	right := s.stitch(roots[split:], matching)
+
+	l, r := &s.nodes[left], &s.nodes[right]
+	if l.left == 0 && r.left == 0 && l.prefix == 1 && r.prefix == 1 {
+		l.prefix = matching - offset
+		s.release(right)
+		s.invalidate(left)
+		return left
+	}
+
+	i := s.alloc(treeNode{addr: first, prefix: matching - offset, left: left, right: right})
+	s.updateCount(i, offset)
+
+	return i
+}
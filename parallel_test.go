@@ -0,0 +1,77 @@
+package ipset
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestNewTreeParallel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	testCases := []struct {
+		desc  string
+		cidrs []*net.IPNet
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc:  "single prefix",
+			cidrs: parseCidrs("10.0.0.0/8"),
+		},
+		{
+			desc:  "halves of the key space",
+			cidrs: parseCidrs("::/1", "8000::/1"),
+		},
+		{
+			desc:  "ipv6 prefix covering ipv4 space",
+			cidrs: parseCidrs("10.0.0.0/8", "::/64", "2001:db8::/32"),
+		},
+		{
+			desc:  "ipv4 space between ipv6 prefixes",
+			cidrs: parseCidrs("10.0.0.0/8", "::1/128", "::1:0:0:0/128"),
+		},
+		{
+			desc:  "ipv4 space complementing ipv6 prefix",
+			cidrs: parseCidrs("0.0.0.0/1", "128.0.0.0/1", "::fffe:0:0/96"),
+		},
+		{
+			desc:  "complementing shards",
+			cidrs: parseCidrs("10.0.0.0/9", "10.128.0.0/9", "10.0.0.0/32", "10.255.255.255/32"),
+		},
+		{
+			desc:  "random mixed",
+			cidrs: append(randomIPv4Cidrs(rng, 5000), randomIPv6Cidrs(rng, 5000)...),
+		},
+		{
+			desc:  "dense ipv4",
+			cidrs: denseIPv4Cidrs(rng, 5000),
+		},
+		{
+			desc:  "bgp like",
+			cidrs: ribLikeCidrs(rng, 20000),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			expected := NewTree(tc.cidrs...).(*ipset)
+
+			for _, workers := range []int{1, 2, 3, 8, 64} {
+				got := NewTreeParallel(tc.cidrs, workers).(*ipset)
+				if err := sameTree(expected, got); err != nil {
+					t.Errorf("mismatch for %d workers: %v", workers, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNewTreeParallel(b *testing.B) {
+	cidrs := ribLikeCidrs(rand.New(rand.NewSource(1)), 1000000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewTreeParallel(cidrs, 8)
+	}
+}
@@ -0,0 +1,69 @@
+package ipset
+
+import (
+	"math/rand"
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// RandomAddress returns address drawn uniformly from covered space, nil for empty set.
+// Result depends only on state of rng, so seeded rng gives reproducible sequence.
+func RandomAddress(t Tree, rng *rand.Rand) net.IP {
+	s := treeOf(t)
+	s.buildCounts()
+	if s.root == 0 {
+		return nil
+	}
+
+	return NthAddress(s, randomBelow(rng, s.size(s.root, 0)))
+}
+
+// RandomPrefix returns one of stored prefixes, drawn with probability proportional to its size
+// when weighted is set and uniformly otherwise, nil for empty set
+func RandomPrefix(t Tree, rng *rand.Rand, weighted bool) *net.IPNet {
+	s := treeOf(t)
+	s.buildCounts()
+	if s.root == 0 {
+		return nil
+	}
+
+	if weighted {
+		first, prefix, _, _ := s.nthLeaf(randomBelow(rng, s.size(s.root, 0)))
+		return netFromAddr(first, prefix)
+	}
+
+	n := uint32(rng.Int63n(int64(s.leafCount(s.root))))
+	curr, offset := s.root, uint32(0)
+	for {
+		node := &s.nodes[curr]
+		offset += node.prefix
+		if node.left == 0 {
+			return netFromAddr(maskAddr(node.addr, offset), offset)
+		}
+
+		if left := s.leafCount(node.left); n < left {
+			curr = node.left
+		} else {
+			n -= left
+			curr = node.right
+		}
+	}
+}
+
+// randomBelow returns uniformly distributed number in [0, n), zero n stands for 2^128
+func randomBelow(rng *rand.Rand, n uint128.Uint128) uint128.Uint128 {
+	if n.IsZero() {
+		return uint128.New(rng.Uint64(), rng.Uint64())
+	}
+
+	// rejection sampling of numbers with bit length of n-1
+	max := n.Sub64(1)
+	mask := hostMask(uint32(128 - max.Len()))
+	for {
+		r := uint128.New(rng.Uint64(), rng.Uint64()).And(mask)
+		if r.Cmp(max) <= 0 {
+			return r
+		}
+	}
+}
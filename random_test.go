@@ -0,0 +1,95 @@
+package ipset
+
+import (
+	"math/rand"
+	"testing"
+
+	"lukechampine.com/uint128"
+)
+
+func TestRandomAddress(t *testing.T) {
+	if RandomAddress(NewTree(), rand.New(rand.NewSource(1))) != nil {
+		t.Errorf("address drawn from empty set")
+	}
+
+	single := NewTree(parseCidrs("10.1.2.3/32")...)
+	if got := RandomAddress(single, rand.New(rand.NewSource(1))); got.String() != "10.1.2.3" {
+		t.Errorf("unexpected address %s", got)
+	}
+
+	full := NewTree(parseCidrs("::/0")...)
+	if got := RandomAddress(full, rand.New(rand.NewSource(1))); got == nil {
+		t.Errorf("no address drawn from whole space")
+	}
+
+	// 10.0.0.0/24 holds half of addresses
+	set := NewTree(parseCidrs("10.0.0.0/24", "10.0.1.0/25", "10.0.2.0/26", "2001:db8::/122")...)
+	block := parseCidrs("10.0.0.0/24")[0]
+	rng := rand.New(rand.NewSource(1))
+	hits := 0
+	for i := 0; i < 10000; i++ {
+		ip := RandomAddress(set, rng)
+		if !set.Contains(ip) {
+			t.Fatalf("%s is not in the set", ip)
+		}
+		if block.Contains(ip) {
+			hits++
+		}
+	}
+	if hits < 4800 || hits > 5200 {
+		t.Errorf("sampling is not uniform, %d of 10000 in half of space", hits)
+	}
+
+	a, b := rand.New(rand.NewSource(7)), rand.New(rand.NewSource(7))
+	for i := 0; i < 100; i++ {
+		if l, r := RandomAddress(set, a), RandomAddress(set, b); !l.Equal(r) {
+			t.Fatalf("seeded sequences differ (%s vs %s)", l, r)
+		}
+	}
+}
+
+func TestRandomPrefix(t *testing.T) {
+	if RandomPrefix(NewTree(), rand.New(rand.NewSource(1)), false) != nil {
+		t.Errorf("prefix drawn from empty set")
+	}
+
+	set := NewTree(parseCidrs("10.0.0.0/8", "192.168.0.0/30", "2001:db8::/127")...)
+	for _, weighted := range []bool{false, true} {
+		counts := map[string]int{}
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 3000; i++ {
+			counts[RandomPrefix(set, rng, weighted).String()]++
+		}
+
+		if weighted {
+			if counts["10.0.0.0/8"] < 2990 {
+				t.Errorf("weighted sampling is not proportional: %v", counts)
+			}
+			continue
+		}
+		for _, prefix := range []string{"10.0.0.0/8", "192.168.0.0/30", "2001:db8::/127"} {
+			if counts[prefix] < 900 || counts[prefix] > 1100 {
+				t.Errorf("uniform sampling is not uniform: %v", counts)
+			}
+		}
+	}
+}
+
+func TestRandomBelow(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []uint128.Uint128{uint128.From64(1), uint128.From64(3), uint128.From64(1 << 40), uint128.New(0, 5), uint128.Max} {
+		for i := 0; i < 100; i++ {
+			if r := randomBelow(rng, n); r.Cmp(n) >= 0 {
+				t.Fatalf("%s is not below %s", r, n)
+			}
+		}
+	}
+
+	seen := map[uint64]bool{}
+	for i := 0; i < 100; i++ {
+		seen[randomBelow(rng, uint128.From64(3)).Lo] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("unexpected values drawn %v", seen)
+	}
+}
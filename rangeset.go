@@ -0,0 +1,151 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	"lukechampine.com/uint128"
+)
+
+// RangeSet is a read only Set kept as sorted array of non overlapping [start, end] intervals,
+// it is smaller and more cache friendly than the tree and answers queries with binary search
+type RangeSet struct {
+	starts []uint128.Uint128
+	ends   []uint128.Uint128
+}
+
+// NewRangeSet compiles set into RangeSet, adjacent prefixes are merged into single interval
+func NewRangeSet(s Set) (*RangeSet, error) {
+	w, ok := s.(prefixWalker)
+	if !ok {
+		return nil, fmt.Errorf("NewRangeSet: unsupported set type %T", s)
+	}
+
+	r := &RangeSet{}
+	w.walk(func(addr uint128.Uint128, prefix uint32) {
+		end := addr.Or(hostMask(prefix))
+		if n := len(r.ends); n > 0 && r.ends[n-1].Cmp(uint128.Max) < 0 && r.ends[n-1].Add64(1).Equals(addr) {
+			r.ends[n-1] = end
+			return
+		}
+
+		r.starts = append(r.starts, addr)
+		r.ends = append(r.ends, end)
+	})
+
+	return r, nil
+}
+
+// Contains tells whether ip is covered by any of intervals
+func (r *RangeSet) Contains(ip net.IP) bool {
+	addr, err := uint128FromIP(ip)
+	if err != nil {
+		return false
+	}
+
+	return r.contains(addr)
+}
+
+// ContainsRawIPv4 tells whether ipv4 address in host order is covered by any of intervals
+func (r *RangeSet) ContainsRawIPv4(ipRaw uint32) bool {
+	return r.contains(ipv4Space.Or64(uint64(ipRaw)))
+}
+
+func (r *RangeSet) contains(addr uint128.Uint128) bool {
+	i := sort.Search(len(r.ends), func(i int) bool {
+		return r.ends[i].Cmp(addr) >= 0
+	})
+
+	return i < len(r.ends) && r.starts[i].Cmp(addr) <= 0
+}
+
+// Len returns number of intervals
+func (r *RangeSet) Len() int {
+	return len(r.starts)
+}
+
+// ToSet converts intervals back into tree based Set
+func (r *RangeSet) ToSet() Set {
+	s := &ipset{}
+	r.walk(func(addr uint128.Uint128, prefix uint32) {
+		s.Add(netFromAddr(addr, prefix))
+	})
+
+	return s
+}
+
+// walk calls fn for minimal list of prefixes covering the intervals in ascending order
+func (r *RangeSet) walk(fn func(addr uint128.Uint128, prefix uint32)) {
+	for i := range r.starts {
+		rangeToPrefixes(r.starts[i], r.ends[i], fn)
+	}
+}
+
+// RangeToCIDRs splits range of addresses from first to last inclusive into minimal list of prefixes
+func RangeToCIDRs(first, last net.IP) ([]*net.IPNet, error) {
+	if (first.To4() == nil) != (last.To4() == nil) {
+		return nil, fmt.Errorf("RangeToCIDRs: %s and %s are of different families", first, last)
+	}
+
+	start, err := uint128FromIP(first)
+	if err != nil {
+		return nil, fmt.Errorf("RangeToCIDRs: %w", err)
+	}
+	end, err := uint128FromIP(last)
+	if err != nil {
+		return nil, fmt.Errorf("RangeToCIDRs: %w", err)
+	}
+	if start.Cmp(end) > 0 {
+		return nil, fmt.Errorf("RangeToCIDRs: %s is past %s", first, last)
+	}
+
+	var cidrs []*net.IPNet
+	rangeToPrefixes(start, end, func(addr uint128.Uint128, prefix uint32) {
+		cidrs = append(cidrs, netFromAddr(addr, prefix))
+	})
+
+	return cidrs, nil
+}
+
+// rangeToPrefixes splits [start, end] interval into minimal list of prefixes
+func rangeToPrefixes(start, end uint128.Uint128, fn func(addr uint128.Uint128, prefix uint32)) {
+	for {
+		// biggest block aligned at start not exceeding end
+		span := end.Sub(start)
+		bits := uint32(start.TrailingZeros())
+		if l := uint32(span.Len()); l < bits {
+			bits = l
+		}
+		if hostMask(128-bits).Cmp(span) > 0 {
+			bits--
+		}
+
+		fn(start, 128-bits)
+
+		last := start.Or(hostMask(128 - bits))
+		if last.Equals(end) {
+			return
+		}
+		start = last.Add64(1)
+	}
+}
+
+// hostMask returns mask with all bits past prefix set
+func hostMask(prefix uint32) uint128.Uint128 {
+	return uint128.Max.Rsh(uint(prefix))
+}
+
+// netFromAddr converts key back into network, ipv4 mapped keys are returned as ipv4 networks
+func netFromAddr(addr uint128.Uint128, prefix uint32) *net.IPNet {
+	ip := make(net.IP, 16)
+	binary.BigEndian.PutUint64(ip[:8], addr.Hi)
+	binary.BigEndian.PutUint64(ip[8:], addr.Lo)
+
+	if prefix >= 96 && matchingPrefix(addr, ipv4Space) >= 96 {
+		return &net.IPNet{IP: ip[12:], Mask: net.CIDRMask(int(prefix-96), 32)}
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(int(prefix), 128)}
+}
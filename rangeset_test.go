@@ -0,0 +1,189 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"testing"
+
+	"lukechampine.com/uint128"
+)
+
+func TestRangeSetContains(t *testing.T) {
+	for _, group := range groups {
+		t.Run(group.name, func(t *testing.T) {
+			s := NewSet(group.cidrs...)
+			r, err := NewRangeSet(s)
+			if err != nil {
+				t.Fatalf("NewRangeSet failed: %v", err)
+			}
+
+			for _, ip := range group.negativeIPs {
+				if r.Contains(ip) {
+					t.Errorf("negative case returned true: %s", ip)
+				}
+			}
+
+			for _, cidr := range group.cidrs {
+				low, high, err := getHostsRangeFromCIDR(cidr.String())
+				if err != nil {
+					t.Fatalf("Getting cidr range failed: %v", err)
+				}
+
+				for i := uint64(low); i <= uint64(high); i++ {
+					if !r.ContainsRawIPv4(uint32(i)) {
+						t.Fatalf("positive case returned false: %d", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRangeSetRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewSet(randomIPv4Cidrs(rng, 2000)...)
+	r, err := NewRangeSet(s)
+	if err != nil {
+		t.Fatalf("NewRangeSet failed: %v", err)
+	}
+	back := r.ToSet()
+
+	ip := make(net.IP, 4)
+	for i := 0; i < 100000; i++ {
+		binary.BigEndian.PutUint32(ip, rng.Uint32())
+		expected := s.Contains(ip)
+		if got := r.Contains(ip); got != expected {
+			t.Fatalf("mismatch for %s (expected: %t, got: %t)", ip, expected, got)
+		}
+		if got := back.Contains(ip); got != expected {
+			t.Fatalf("round trip mismatch for %s (expected: %t, got: %t)", ip, expected, got)
+		}
+	}
+}
+
+func TestRangeSetMergesAdjacent(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		cidrs  []*net.IPNet
+		ranges int
+	}{
+		{
+			desc:   "adjacent blocks of different size",
+			cidrs:  parseCidrs("10.0.1.0/24", "10.0.2.0/23", "10.0.4.0/22", "10.0.8.0/25"),
+			ranges: 1,
+		},
+		{
+			desc:   "separated blocks",
+			cidrs:  parseCidrs("10.0.1.0/24", "10.0.3.0/24"),
+			ranges: 2,
+		},
+		{
+			desc:   "ipv4 and ipv6",
+			cidrs:  parseCidrs("::/0"),
+			ranges: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r, err := NewRangeSet(NewSet(tc.cidrs...))
+			if err != nil {
+				t.Fatalf("NewRangeSet failed: %v", err)
+			}
+
+			if r.Len() != tc.ranges {
+				t.Errorf("mismatch (expected: %d, got: %d)", tc.ranges, r.Len())
+			}
+		})
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		start    uint128.Uint128
+		end      uint128.Uint128
+		expected []string
+	}{
+		{
+			desc:     "single address",
+			start:    ipv4Space.Or64(0x0a000001),
+			end:      ipv4Space.Or64(0x0a000001),
+			expected: []string{"10.0.0.1/32"},
+		},
+		{
+			desc:     "unaligned range",
+			start:    ipv4Space.Or64(0x0a000001),
+			end:      ipv4Space.Or64(0x0a000006),
+			expected: []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"},
+		},
+		{
+			desc:     "whole ipv4 space",
+			start:    ipv4Space,
+			end:      ipv4Space.Or64(0xffffffff),
+			expected: []string{"0.0.0.0/0"},
+		},
+		{
+			desc:     "whole key space",
+			start:    uint128.Zero,
+			end:      uint128.Max,
+			expected: []string{"::/0"},
+		},
+		{
+			desc:     "last address",
+			start:    uint128.Max.Sub64(2),
+			end:      uint128.Max,
+			expected: []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffd/128", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			rangeToPrefixes(tc.start, tc.end, func(addr uint128.Uint128, prefix uint32) {
+				got = append(got, netFromAddr(addr, prefix).String())
+			})
+
+			if len(got) != len(tc.expected) {
+				t.Fatalf("mismatch (expected: %v, got: %v)", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Errorf("mismatch (expected: %v, got: %v)", tc.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRangeToCIDRs(t *testing.T) {
+	cidrs, err := RangeToCIDRs(net.ParseIP("2.0.0.0"), net.ParseIP("2.0.11.255"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cidrs) != 2 || cidrs[0].String() != "2.0.0.0/21" || cidrs[1].String() != "2.0.8.0/22" {
+		t.Errorf("unexpected cidrs %v", cidrs)
+	}
+
+	invalid := [][2]string{
+		{"10.0.0.2", "10.0.0.1"},
+		{"10.0.0.1", "2001:db8::1"},
+	}
+	for _, r := range invalid {
+		if _, err := RangeToCIDRs(net.ParseIP(r[0]), net.ParseIP(r[1])); err == nil {
+			t.Errorf("range %s - %s was accepted", r[0], r[1])
+		}
+	}
+}
+
+func BenchmarkRangeSetContainsRawIPv4(b *testing.B) {
+	r, err := NewRangeSet(NewSet(randomIPv4Cidrs(rand.New(rand.NewSource(1)), 100000)...))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ips := benchmarkIPs(1 << 16)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ContainsRawIPv4(ips[i&0xffff])
+	}
+}
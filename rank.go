@@ -0,0 +1,87 @@
+package ipset
+
+import (
+	"net"
+
+	"lukechampine.com/uint128"
+)
+
+// Rank returns number of covered addresses below ip, addresses are ordered as keys
+// so ipv6 ones below ::ffff:0:0 precede all ipv4 ones. It takes O(depth) using counts of subtrees,
+// the first call counts the whole tree. Trees of other types are rebuilt and counted on every call.
+func Rank(t Tree, ip net.IP) uint128.Uint128 {
+	s := treeOf(t)
+	s.buildCounts()
+	addr, err := uint128FromIP(ip)
+	if err != nil || s.root == 0 {
+		return uint128.Zero
+	}
+
+	rank := uint128.Zero
+	curr, offset := s.root, uint32(0)
+	for {
+		n := &s.nodes[curr]
+		parentOffset := offset
+		offset += n.prefix
+
+		first := maskAddr(n.addr, offset)
+		if addr.Cmp(first) <= 0 {
+			return rank
+		}
+		if addr.Cmp(first.Or(hostMask(offset))) > 0 {
+			return rank.Add(s.size(curr, parentOffset))
+		}
+		if n.left == 0 {
+			return rank.Add(addr.Sub(first))
+		}
+
+		if addr.Rsh(uint(128-(offset+1))).And64(0x01) == uint128.Zero {
+			curr = n.left
+		} else {
+			rank = rank.Add(s.size(n.left, offset))
+			curr = n.right
+		}
+	}
+}
+
+// NthAddress returns covered address of given rank, nil if there are not as many addresses in the set.
+// Like Rank it takes O(depth) for trees created by this package only.
+func NthAddress(t Tree, n uint128.Uint128) net.IP {
+	s := treeOf(t)
+	s.buildCounts()
+	first, _, rest, ok := s.nthLeaf(n)
+	if !ok {
+		return nil
+	}
+
+	return netFromAddr(first.Add(rest), 128).IP
+}
+
+// nthLeaf returns prefix holding covered address of given rank and rank of the address within the prefix,
+// counts have to be built
+func (s *ipset) nthLeaf(n uint128.Uint128) (uint128.Uint128, uint32, uint128.Uint128, bool) {
+	if s.root == 0 {
+		return uint128.Zero, 0, uint128.Zero, false
+	}
+
+	curr, offset := s.root, uint32(0)
+	for {
+		node := &s.nodes[curr]
+		offset += node.prefix
+		if node.left == 0 {
+			if n.Cmp(hostMask(offset)) > 0 {
+				return uint128.Zero, 0, uint128.Zero, false
+			}
+			return maskAddr(node.addr, offset), offset, n, true
+		}
+
+		if left := s.size(node.left, offset); n.Cmp(left) < 0 {
+			curr = node.left
+		} else if n.Cmp(s.counts[curr].addrs) < 0 {
+			n = n.Sub(left)
+			curr = node.right
+		} else {
+			return uint128.Zero, 0, uint128.Zero, false
+		}
+	}
+}
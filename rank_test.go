@@ -0,0 +1,89 @@
+package ipset
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"lukechampine.com/uint128"
+)
+
+func TestRank(t *testing.T) {
+	set := NewTree(parseCidrs("10.0.0.0/24", "10.0.1.0/25", "10.0.2.7/32", "::/127")...)
+	testCases := []struct {
+		ip   string
+		rank uint64
+	}{
+		{ip: "::", rank: 0},
+		{ip: "::1", rank: 1},
+		{ip: "::2", rank: 2},
+		{ip: "0.0.0.0", rank: 2},
+		{ip: "10.0.0.0", rank: 2},
+		{ip: "10.0.0.100", rank: 102},
+		{ip: "10.0.1.0", rank: 258},
+		{ip: "10.0.1.200", rank: 386},
+		{ip: "10.0.2.7", rank: 386},
+		{ip: "10.0.2.8", rank: 387},
+		{ip: "2001:db8::", rank: 387},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			if got := Rank(set, net.ParseIP(tc.ip)); !got.Equals64(tc.rank) {
+				t.Errorf("rank mismatch (expected: %d, got: %s)", tc.rank, got)
+			}
+		})
+	}
+
+	for n, expected := range map[uint64]string{0: "::", 2: "10.0.0.0", 258: "10.0.1.0", 386: "10.0.2.7", 387: "<nil>"} {
+		if got := NthAddress(set, uint128.From64(n)); got.String() != expected {
+			t.Errorf("NthAddress(%d) mismatch (expected: %s, got: %s)", n, expected, got)
+		}
+	}
+
+	full := NewTree(parseCidrs("::/0")...)
+	if got := NthAddress(full, uint128.Max); !got.Equal(net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")) {
+		t.Errorf("unexpected last address of whole space %s", got)
+	}
+	if got := Rank(full, net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")); !got.Equals(uint128.Max) {
+		t.Errorf("unexpected rank of last address %s", got)
+	}
+}
+
+func TestRankRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	set := NewTree().(*ipset)
+	for i, cidr := range denseIPv4Cidrs(rng, 1000) {
+		if i%3 == 2 {
+			set.Remove(cidr)
+		} else {
+			set.Add(cidr)
+		}
+		if i%50 != 0 {
+			continue
+		}
+
+		var b Builder
+		for _, prefix := range set.Prefixes() {
+			b.Add(prefix)
+		}
+		if err := sameTree(set, b.Build().(*ipset)); err != nil {
+			t.Fatalf("tree mismatch after %d mutations: %v", i, err)
+		}
+
+		rank := uint64(0)
+		for j := 0; j < 1<<12; j++ {
+			ip := net.IPv4(10, 0, byte(j>>8), byte(j)).To4()
+			if got := Rank(set, ip); !got.Equals64(rank) {
+				t.Fatalf("rank of %s mismatch (expected: %d, got: %s)", ip, rank, got)
+			}
+			if !set.Contains(ip) {
+				continue
+			}
+
+			if got := NthAddress(set, uint128.From64(rank)); !got.Equal(ip) {
+				t.Fatalf("NthAddress(%d) mismatch (expected: %s, got: %s)", rank, ip, got)
+			}
+			rank++
+		}
+	}
+}
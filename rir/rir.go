@@ -0,0 +1,147 @@
+// Package rir parses delegated statistics files published by regional internet registries,
+// in both the plain and the extended format.
+package rir
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kentik/ipset"
+)
+
+// Statuses of delegated records
+const (
+	Allocated = "allocated"
+	Assigned  = "assigned"
+	Available = "available"
+	Reserved  = "reserved"
+)
+
+// Record is a block of ipv4 or ipv6 addresses delegated to a country
+type Record struct {
+	Registry string
+	Country  string
+	Status   string
+	// Prefixes cover the block, ipv4 blocks of size other than power of two are split into several prefixes
+	Prefixes []*net.IPNet
+}
+
+// Parse reads ipv4 and ipv6 records of delegated file, header, summary and asn lines are skipped
+func Parse(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(text, "|")
+		if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			// version line
+			continue
+		}
+		if len(fields) == 6 && fields[5] == "summary" {
+			continue
+		}
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("rir: line %d: expected at least 7 fields, got %d", line, len(fields))
+		}
+		if fields[2] != "ipv4" && fields[2] != "ipv6" {
+			continue
+		}
+
+		prefixes, err := parsePrefixes(fields[2], fields[3], fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("rir: line %d: %w", line, err)
+		}
+
+		records = append(records, Record{
+			Registry: fields[0],
+			Country:  strings.ToUpper(fields[1]),
+			Status:   fields[6],
+			Prefixes: prefixes,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("rir: %w", err)
+	}
+
+	return records, nil
+}
+
+// parsePrefixes converts ipv4 start and address count or ipv6 start and prefix length into prefixes
+func parsePrefixes(family, start, value string) ([]*net.IPNet, error) {
+	ip := net.ParseIP(start)
+	if ip == nil || (family == "ipv4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid %s start %q", family, start)
+	}
+
+	if family == "ipv6" {
+		_, cidr, err := net.ParseCIDR(start + "/" + value)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.IPNet{cidr}, nil
+	}
+
+	count, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || count == 0 {
+		return nil, fmt.Errorf("invalid address count %q", value)
+	}
+	first := uint64(binary.BigEndian.Uint32(ip.To4()))
+	if first+count > 1<<32 {
+		return nil, fmt.Errorf("%d addresses starting at %s exceed ipv4 space", count, start)
+	}
+
+	last := make(net.IP, 4)
+	binary.BigEndian.PutUint32(last, uint32(first+count-1))
+
+	return ipset.RangeToCIDRs(ip, last)
+}
+
+// CountrySets groups prefixes of records by country. Only records of given statuses are taken,
+// allocated and assigned ones when none are given. Records without country are skipped.
+func CountrySets(records []Record, statuses ...string) map[string]ipset.Set {
+	if len(statuses) == 0 {
+		statuses = []string{Allocated, Assigned}
+	}
+
+	builders := make(map[string]*ipset.Builder)
+	for _, r := range records {
+		if r.Country == "" || !hasStatus(statuses, r.Status) {
+			continue
+		}
+
+		b := builders[r.Country]
+		if b == nil {
+			b = &ipset.Builder{}
+			builders[r.Country] = b
+		}
+		for _, prefix := range r.Prefixes {
+			b.Add(prefix)
+		}
+	}
+
+	sets := make(map[string]ipset.Set, len(builders))
+	for country, b := range builders {
+		sets[country] = b.Build()
+	}
+
+	return sets
+}
+
+func hasStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+
+	return false
+}
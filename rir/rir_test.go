@@ -0,0 +1,89 @@
+package rir
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/delegated-ripencc-extended-latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range records {
+		var prefixes []string
+		for _, p := range r.Prefixes {
+			prefixes = append(prefixes, p.String())
+		}
+		got = append(got, r.Country+" "+r.Status+" "+strings.Join(prefixes, ","))
+	}
+	expected := []string{
+		"FR allocated 2.0.0.0/12",
+		"IT assigned 2.16.0.0/21,2.16.8.0/22",
+		"FR allocated 5.39.0.0/22,5.39.4.0/23",
+		"DE allocated 2001:608::/32",
+		"FR allocated 2001:660::/32",
+		" available 2.57.180.0/22",
+		"ZZ reserved 5.44.248.0/21",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("mismatch (expected: %q, got: %q)", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("mismatch (expected: %q, got: %q)", expected[i], got[i])
+		}
+	}
+
+	sets := CountrySets(records)
+	if len(sets) != 3 {
+		t.Errorf("unexpected countries %v", sets)
+	}
+	testCases := []struct {
+		country  string
+		ip       string
+		contains bool
+	}{
+		{country: "FR", ip: "2.15.255.255", contains: true},
+		{country: "FR", ip: "2.16.0.1", contains: false},
+		{country: "FR", ip: "5.39.5.255", contains: true},
+		{country: "FR", ip: "5.39.6.0", contains: false},
+		{country: "FR", ip: "2001:660::1", contains: true},
+		{country: "IT", ip: "2.16.11.1", contains: true},
+		{country: "DE", ip: "2001:609::1", contains: false},
+	}
+	for _, tc := range testCases {
+		if sets[tc.country].Contains(net.ParseIP(tc.ip)) != tc.contains {
+			t.Errorf("unexpected result for %s in %s", tc.ip, tc.country)
+		}
+	}
+
+	if sets := CountrySets(records, Reserved); len(sets) != 1 || !sets["ZZ"].Contains(net.ParseIP("5.44.255.1")) {
+		t.Errorf("unexpected reserved sets %v", sets)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	lines := []string{
+		"ripencc|FR|ipv4|2.0.0.0|0|20100712|allocated",
+		"ripencc|FR|ipv4|255.255.255.0|512|20100712|allocated",
+		"ripencc|FR|ipv4|2001:660::|32|20100712|allocated",
+		"ripencc|FR|ipv6|2001:660::|129|20100712|allocated",
+		"ripencc|FR|ipv4|2.0.0.0",
+	}
+	for _, line := range lines {
+		if _, err := Parse(strings.NewReader(line)); err == nil {
+			t.Errorf("invalid line was accepted: %s", line)
+		}
+	}
+}
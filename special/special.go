@@ -0,0 +1,174 @@
+// Package special provides well-known special-purpose address blocks as read-only sets,
+// and classifies addresses by IANA special-purpose address registries.
+package special
+
+import (
+	"net"
+
+	"github.com/kentik/ipset"
+)
+
+// readOnly hides mutating methods of the tree
+type readOnly struct {
+	tree ipset.Tree
+}
+
+func (r readOnly) Contains(ip net.IP) bool {
+	return r.tree.Contains(ip)
+}
+
+func (r readOnly) ContainsRawIPv4(ipRaw uint32) bool {
+	return r.tree.ContainsRawIPv4(ipRaw)
+}
+
+// Prefixes returns minimal list of prefixes of the set
+func (r readOnly) Prefixes() []*net.IPNet {
+	return r.tree.Prefixes()
+}
+
+func newSet(cidrs ...string) readOnly {
+	tree := ipset.NewTree()
+	for _, cidr := range cidrs {
+		tree.Add(mustParse(cidr))
+	}
+
+	return readOnly{tree: tree}
+}
+
+func mustParse(cidr string) *net.IPNet {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+var (
+	private        = newSet("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16")
+	sharedAddress  = newSet("100.64.0.0/10")
+	loopback       = newSet("127.0.0.0/8", "::1/128")
+	linkLocal      = newSet("169.254.0.0/16", "fe80::/10")
+	multicast      = newSet("224.0.0.0/4", "ff00::/8")
+	documentation  = newSet("192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/32", "3fff::/20")
+	uniqueLocal    = newSet("fc00::/7")
+	sixToFour      = newSet("2002::/16")
+	teredo         = newSet("2001::/32")
+	specialPurpose = registrySet()
+	bogons         = bogonSet()
+
+	blocks = registryMap()
+)
+
+// Private returns RFC 1918 private-use blocks
+func Private() ipset.Set { return private }
+
+// SharedAddressSpace returns RFC 6598 block used by carrier-grade NAT, 100.64.0.0/10
+func SharedAddressSpace() ipset.Set { return sharedAddress }
+
+// Loopback returns ipv4 and ipv6 loopback blocks
+func Loopback() ipset.Set { return loopback }
+
+// LinkLocal returns ipv4 and ipv6 link-local blocks
+func LinkLocal() ipset.Set { return linkLocal }
+
+// Multicast returns ipv4 and ipv6 multicast blocks
+func Multicast() ipset.Set { return multicast }
+
+// Documentation returns blocks reserved for documentation and examples
+func Documentation() ipset.Set { return documentation }
+
+// UniqueLocal returns RFC 4193 ipv6 unique local block, fc00::/7
+func UniqueLocal() ipset.Set { return uniqueLocal }
+
+// SixToFour returns 6to4 block, 2002::/16
+func SixToFour() ipset.Set { return sixToFour }
+
+// Teredo returns Teredo block, 2001::/32
+func Teredo() ipset.Set { return teredo }
+
+// SpecialPurpose returns all blocks of IANA special-purpose registries and multicast blocks
+func SpecialPurpose() ipset.Set { return specialPurpose }
+
+// Bogons returns blocks which should never be routed on the internet: special-purpose blocks
+// which are not globally reachable, including multicast, and ipv6 blocks reserved or deprecated
+// outside of the registry, like ::/8 and site-local fec0::/10. Teredo and 6to4 are not bogons.
+func Bogons() ipset.Set { return bogons }
+
+func registrySet() readOnly {
+	tree := ipset.NewTree()
+	for _, e := range registry {
+		tree.Add(mustParse(e.prefix))
+	}
+
+	return readOnly{tree: tree}
+}
+
+func bogonSet() readOnly {
+	tree := ipset.NewTree()
+	// ::/8 holds ipv4 addresses at ::ffff:0:0/96
+	tree.Add(mustParse("::/8"))
+	tree.Remove(mustParse("0.0.0.0/0"))
+	tree.Add(mustParse("3ffe::/16"))
+	tree.Add(mustParse("fec0::/10"))
+
+	for _, e := range registry {
+		if !e.global {
+			tree.Add(mustParse(e.prefix))
+		}
+	}
+	// globally reachable blocks nested in other ones, and tunneling blocks whose reachability isn't applicable
+	for _, e := range registry {
+		if e.global || e.prefix == "2001::/32" || e.prefix == "2002::/16" {
+			tree.Remove(mustParse(e.prefix))
+		}
+	}
+
+	return readOnly{tree: tree}
+}
+
+// Block is an entry of special-purpose address registry
+type Block struct {
+	Prefix *net.IPNet
+	Name   string
+	RFC    string
+	// Source tells whether address from the block is valid as source address
+	Source bool
+	// Destination tells whether address from the block is valid as destination address
+	Destination bool
+	// Forwardable tells whether router may forward packet with address from the block
+	Forwardable bool
+	// GloballyReachable tells whether address from the block is reachable from the internet
+	GloballyReachable bool
+	// ReservedByProtocol tells whether the block is reserved by the protocol itself
+	ReservedByProtocol bool
+}
+
+func registryMap() *ipset.Map {
+	m := ipset.NewMap()
+	for _, e := range registry {
+		prefix := mustParse(e.prefix)
+		m.Insert(prefix, &Block{
+			Prefix:             prefix,
+			Name:               e.name,
+			RFC:                e.rfc,
+			Source:             e.source,
+			Destination:        e.destination,
+			Forwardable:        e.forwardable,
+			GloballyReachable:  e.global,
+			ReservedByProtocol: e.reserved,
+		})
+	}
+
+	return m
+}
+
+// Classify returns the most specific special-purpose block holding ip, the returned block must not be modified
+func Classify(ip net.IP) (*Block, bool) {
+	v, _, ok := blocks.Lookup(ip)
+	if !ok {
+		return nil, false
+	}
+
+	return v.(*Block), true
+}
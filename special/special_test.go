@@ -0,0 +1,92 @@
+package special
+
+import (
+	"net"
+	"testing"
+
+	"github.com/kentik/ipset"
+)
+
+func TestSets(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		set      ipset.Set
+		positive []string
+		negative []string
+	}{
+		{desc: "private", set: Private(), positive: []string{"10.1.2.3", "172.31.255.255", "192.168.0.1"}, negative: []string{"172.32.0.0", "fc00::1"}},
+		{desc: "shared address space", set: SharedAddressSpace(), positive: []string{"100.127.255.255"}, negative: []string{"100.128.0.0"}},
+		{desc: "loopback", set: Loopback(), positive: []string{"127.0.0.1", "::1"}, negative: []string{"::2"}},
+		{desc: "link local", set: LinkLocal(), positive: []string{"169.254.1.1", "fe80::1"}, negative: []string{"fec0::1"}},
+		{desc: "multicast", set: Multicast(), positive: []string{"239.255.255.250", "ff02::1"}, negative: []string{"240.0.0.1"}},
+		{desc: "documentation", set: Documentation(), positive: []string{"192.0.2.1", "2001:db8::1", "3fff::1"}, negative: []string{"192.0.3.1"}},
+		{desc: "unique local", set: UniqueLocal(), positive: []string{"fd12:3456::1"}, negative: []string{"fe00::1"}},
+		{desc: "6to4", set: SixToFour(), positive: []string{"2002:c000:204::1"}, negative: []string{"2003::1"}},
+		{desc: "teredo", set: Teredo(), positive: []string{"2001:0:4136:e378::1"}, negative: []string{"2001:1::1"}},
+		{
+			desc:     "bogons",
+			set:      Bogons(),
+			positive: []string{"0.1.2.3", "10.0.0.1", "100.64.0.1", "192.0.0.1", "224.0.0.1", "255.255.255.255", "::", "::1", "::1.2.3.4", "fec0::1", "fc00::1", "2001:db8::1", "64:ff9b:1::1"},
+			negative: []string{"8.8.8.8", "192.0.0.9", "192.31.196.1", "64:ff9b::808:808", "2001::1", "2002::1", "2001:4860::1", "2001:1::1"},
+		},
+		{desc: "special purpose", set: SpecialPurpose(), positive: []string{"192.88.99.1", "2620:4f:8000::1"}, negative: []string{"1.1.1.1", "2620:4f:8001::1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			for _, ip := range tc.positive {
+				if !tc.set.Contains(net.ParseIP(ip)) {
+					t.Errorf("%s is not contained", ip)
+				}
+			}
+			for _, ip := range tc.negative {
+				if tc.set.Contains(net.ParseIP(ip)) {
+					t.Errorf("%s is contained", ip)
+				}
+			}
+		})
+	}
+
+	if _, ok := Private().(ipset.Tree); ok {
+		t.Errorf("shared set can be modified")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	testCases := []struct {
+		ip     string
+		prefix string
+		name   string
+		global bool
+	}{
+		{ip: "10.1.2.3", prefix: "10.0.0.0/8", name: "Private-Use"},
+		{ip: "0.0.0.0", prefix: "0.0.0.0/32", name: "This host on this network"},
+		{ip: "0.0.0.1", prefix: "0.0.0.0/8", name: "This network"},
+		{ip: "192.0.0.9", prefix: "192.0.0.9/32", name: "Port Control Protocol Anycast", global: true},
+		{ip: "192.0.0.100", prefix: "192.0.0.0/24", name: "IETF Protocol Assignments"},
+		{ip: "2001:1::2", prefix: "2001:1::2/128", name: "Traversal Using Relays around NAT Anycast", global: true},
+		{ip: "2001:0:4136:e378::1", prefix: "2001::/32", name: "TEREDO"},
+		{ip: "64:ff9b::808:808", prefix: "64:ff9b::/96", name: "IPv4-IPv6 Translation", global: true},
+		{ip: "::", prefix: "::/128", name: "Unspecified Address"},
+		{ip: "8.8.8.8"},
+		{ip: "2001:4860::8888"},
+	}
+	for _, tc := range testCases {
+		block, ok := Classify(net.ParseIP(tc.ip))
+		if ok != (tc.prefix != "") {
+			t.Errorf("unexpected result for %s: %+v", tc.ip, block)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if block.Prefix.String() != tc.prefix || block.Name != tc.name || block.GloballyReachable != tc.global {
+			t.Errorf("mismatch for %s (expected: %s %q %t, got: %s %q %t)", tc.ip,
+				tc.prefix, tc.name, tc.global, block.Prefix, block.Name, block.GloballyReachable)
+		}
+	}
+
+	if block, _ := Classify(net.ParseIP("127.0.0.1")); block.Source || !block.ReservedByProtocol || block.RFC != "RFC 1122" {
+		t.Errorf("unexpected loopback attributes %+v", block)
+	}
+}
@@ -0,0 +1,61 @@
+package ipset
+
+import "unsafe"
+
+// TreeStats describes shape of the tree
+type TreeStats struct {
+	// Nodes is number of all tree nodes, Leaves number of stored prefixes
+	Nodes  int
+	Leaves int
+	// MaxDepth and AvgDepth are numbers of nodes above the deepest and an average leaf
+	MaxDepth int
+	AvgDepth float64
+	// IPv4Prefixes and IPv6Prefixes count stored prefixes by their length
+	IPv4Prefixes [33]int
+	IPv6Prefixes [129]int
+	// MemoryBytes is estimated memory held by the tree, including unused capacity
+	MemoryBytes int
+}
+
+// Stats walks the tree collecting its statistics
+func Stats(t Tree) TreeStats {
+	s := treeOf(t)
+	st := TreeStats{
+		MemoryBytes: int(unsafe.Sizeof(*s)) +
+			cap(s.nodes)*int(unsafe.Sizeof(treeNode{})) +
+			cap(s.free)*int(unsafe.Sizeof(uint32(0))) +
+			cap(s.counts)*int(unsafe.Sizeof(nodeCount{})) +
+			cap(s.hashes)*int(unsafe.Sizeof(nodeHash{})),
+	}
+	if s.root == 0 {
+		return st
+	}
+
+	depths := 0
+	var visit func(i, offset uint32, depth int)
+	visit = func(i, offset uint32, depth int) {
+		n := &s.nodes[i]
+		offset += n.prefix
+		st.Nodes++
+		if n.left != 0 {
+			visit(n.left, offset, depth+1)
+			visit(n.right, offset, depth+1)
+			return
+		}
+
+		st.Leaves++
+		depths += depth
+		if depth > st.MaxDepth {
+			st.MaxDepth = depth
+		}
+		if isIPv4Node(&treeNode{addr: n.addr, prefix: offset}) {
+			st.IPv4Prefixes[offset-96]++
+		} else {
+			st.IPv6Prefixes[offset]++
+		}
+	}
+	visit(s.root, 0, 0)
+	st.AvgDepth = float64(depths) / float64(st.Leaves)
+
+	return st
+}
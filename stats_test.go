@@ -0,0 +1,39 @@
+package ipset
+
+import (
+	"net"
+	"testing"
+	"unsafe"
+)
+
+func TestStats(t *testing.T) {
+	if st := Stats(NewTree()); st.Nodes != 0 || st.Leaves != 0 || st.AvgDepth != 0 {
+		t.Errorf("unexpected stats of empty tree %+v", st)
+	}
+
+	set := NewTree(parseCidrs("10.0.0.0/8", "192.168.0.0/24", "192.168.1.0/25", "2001:db8::/32")...)
+	st := Stats(set)
+	if st.Nodes != 7 || st.Leaves != 4 {
+		t.Errorf("unexpected node counts (nodes: %d, leaves: %d)", st.Nodes, st.Leaves)
+	}
+	// root splits ipv6 and ipv4, ipv4 node splits 10/8 and 192.168/23 holding both 192.168 prefixes
+	if st.MaxDepth != 3 || st.AvgDepth != 2.25 {
+		t.Errorf("unexpected depths (max: %d, avg: %f)", st.MaxDepth, st.AvgDepth)
+	}
+	if st.IPv4Prefixes[8] != 1 || st.IPv4Prefixes[24] != 1 || st.IPv4Prefixes[25] != 1 || st.IPv6Prefixes[32] != 1 {
+		t.Errorf("unexpected histograms %v %v", st.IPv4Prefixes, st.IPv6Prefixes)
+	}
+	if st.MemoryBytes < st.Nodes*int(unsafe.Sizeof(treeNode{})) {
+		t.Errorf("memory estimate %d is below size of nodes", st.MemoryBytes)
+	}
+
+	// every other /24 of a /16, leaves hang at the same depth
+	var cidrs []*net.IPNet
+	for i := 0; i < 256; i += 2 {
+		cidrs = append(cidrs, &net.IPNet{IP: net.IPv4(10, 0, byte(i), 0).To4(), Mask: net.CIDRMask(24, 32)})
+	}
+	st = Stats(NewTree(cidrs...))
+	if st.Leaves != 128 || st.Nodes != 255 || st.MaxDepth != 7 || st.AvgDepth != 7 || st.IPv4Prefixes[24] != 128 {
+		t.Errorf("unexpected stats of de-aggregated prefixes %+v", st)
+	}
+}
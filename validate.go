@@ -0,0 +1,139 @@
+package ipset
+
+import "fmt"
+
+// Validate walks the tree checking its invariants: every node is either referenced once or free,
+// children share prefix of their parent and hang on the side given by the next bit, internal nodes
+// have both children, cumulative prefix doesn't exceed 128, complementing leaves are merged and
+// cached counts and fingerprints are up to date. It returns description of the first violation found, or an error
+// for trees not created by this package.
+func Validate(t Tree) error {
+	s, ok := t.(*ipset)
+	if !ok {
+		return fmt.Errorf("Validate: unsupported tree type %T", t)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make([]bool, len(s.nodes))
+	for _, i := range s.free {
+		if i == 0 || int(i) >= len(s.nodes) || seen[i] {
+			return fmt.Errorf("Validate: invalid free slot %d", i)
+		}
+		seen[i] = true
+	}
+
+	var visit func(i, offset uint32) error
+	visit = func(i, offset uint32) error {
+		if i == 0 || int(i) >= len(s.nodes) {
+			return fmt.Errorf("Validate: invalid node index %d", i)
+		}
+		if seen[i] {
+			return fmt.Errorf("Validate: node %d is free or referenced twice", i)
+		}
+		seen[i] = true
+
+		n := &s.nodes[i]
+		if offset+n.prefix > 128 || offset+n.prefix < offset {
+			return fmt.Errorf("Validate: node %d (%s) is %d bits deep", i, n, offset+n.prefix)
+		}
+		offset += n.prefix
+
+		if (n.left == 0) != (n.right == 0) {
+			return fmt.Errorf("Validate: node %d (%s) has only one child", i, n)
+		}
+		if n.left == 0 {
+			return nil
+		}
+		if offset == 128 {
+			return fmt.Errorf("Validate: node %d (%s) of full length has children", i, n)
+		}
+
+		for side, child := range [2]uint32{n.left, n.right} {
+			if child == 0 || int(child) >= len(s.nodes) {
+				return fmt.Errorf("Validate: node %d (%s) has invalid child index %d", i, n, child)
+			}
+
+			c := &s.nodes[child]
+			if c.prefix == 0 {
+				return fmt.Errorf("Validate: node %d (%s) has child %d not deeper than itself", i, n, child)
+			}
+			if matchingPrefix(c.addr, n.addr) < offset {
+				return fmt.Errorf("Validate: child %d (%s) doesn't share prefix of node %d (%s)", child, c, i, n)
+			}
+			if bit := c.addr.Rsh(uint(128 - (offset + 1))).And64(0x01); !bit.Equals64(uint64(side)) {
+				return fmt.Errorf("Validate: child %d (%s) hangs on wrong side of node %d (%s)", child, c, i, n)
+			}
+		}
+
+		if err := visit(n.left, offset); err != nil {
+			return err
+		}
+		if err := visit(n.right, offset); err != nil {
+			return err
+		}
+
+		left, right := &s.nodes[n.left], &s.nodes[n.right]
+		if left.left == 0 && right.left == 0 && left.prefix == 1 && right.prefix == 1 {
+			return fmt.Errorf("Validate: node %d (%s) has complementing leaves not merged", i, n)
+		}
+
+		if s.counts != nil {
+			if int(i) >= len(s.counts) {
+				return fmt.Errorf("Validate: node %d (%s) has no counts", i, n)
+			}
+			c := s.counts[i]
+			if !s.size(n.left, offset).Add(s.size(n.right, offset)).Equals(c.addrs) || s.leafCount(n.left)+s.leafCount(n.right) != c.leaves {
+				return fmt.Errorf("Validate: node %d (%s) has stale counts (%s/%d)", i, n, c.addrs, c.leaves)
+			}
+		}
+
+		return nil
+	}
+
+	if s.root != 0 {
+		if err := visit(s.root, 0); err != nil {
+			return err
+		}
+	}
+	// slot 0 is never used, it stands for missing child
+	for i := 1; i < len(seen); i++ {
+		if !seen[i] {
+			return fmt.Errorf("Validate: node %d is leaked, neither referenced nor free", i)
+		}
+	}
+
+	if s.root == 0 || len(s.hashes) == 0 {
+		return nil
+	}
+	_, err := s.validateHashes(s.root, 0)
+	return err
+}
+
+// validateHashes computes fingerprint of subtree of node i hanging at offset without using the cache
+// and checks cached fingerprints of its nodes against it
+func (s *ipset) validateHashes(i, offset uint32) ([32]byte, error) {
+	n := &s.nodes[i]
+	offset += n.prefix
+
+	var sum [32]byte
+	if n.left == 0 {
+		sum = leafHash(maskAddr(n.addr, offset), offset)
+	} else {
+		left, err := s.validateHashes(n.left, offset)
+		if err != nil {
+			return sum, err
+		}
+		right, err := s.validateHashes(n.right, offset)
+		if err != nil {
+			return sum, err
+		}
+		sum = nodeSum(left, right)
+	}
+
+	if int(i) < len(s.hashes) && s.hashes[i].valid && s.hashes[i].sum != sum {
+		return sum, fmt.Errorf("Validate: node %d (%s) has stale fingerprint", i, n)
+	}
+
+	return sum, nil
+}
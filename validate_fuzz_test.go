@@ -0,0 +1,54 @@
+//go:build go1.18
+// +build go1.18
+
+package ipset
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// FuzzMutations reads operations from input, each is a flags byte followed by prefix length and
+// 4 or 16 bytes of address, and validates the tree after every one of them. Flags select removal (1),
+// ipv6 address (2) and random mutation (4).
+func FuzzMutations(f *testing.F) {
+	f.Add([]byte{0, 24, 10, 0, 0, 0, 1, 26, 10, 0, 0, 64})
+	f.Add([]byte{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 120, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 0})
+	f.Add([]byte{0, 1, 0, 0, 0, 0, 0, 1, 128, 0, 0, 0, 4, 32, 10, 1, 2, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rng := rand.New(rand.NewSource(int64(len(data))))
+		s := NewTree()
+		for len(data) >= 2 {
+			flags, ones := data[0], int(data[1])
+			size := 4
+			if flags&2 != 0 {
+				size = 16
+			}
+			if len(data) < 2+size {
+				return
+			}
+
+			ip := net.IP(append([]byte{}, data[2:2+size]...))
+			mask := net.CIDRMask(ones%(8*size+1), 8*size)
+			cidr := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+			data = data[2+size:]
+
+			switch {
+			case flags&4 != 0:
+				if err := mutate(rng, s, cidr, nil); err != nil {
+					t.Fatal(err)
+				}
+			case flags&1 != 0:
+				s.Remove(cidr)
+			default:
+				s.Add(cidr)
+			}
+
+			if err := Validate(s); err != nil {
+				t.Fatalf("invalid tree after mutation with %s: %v", cidr, err)
+			}
+		}
+	})
+}
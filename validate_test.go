@@ -0,0 +1,267 @@
+package ipset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"lukechampine.com/uint128"
+)
+
+func TestValidate(t *testing.T) {
+	cidrs := parseCidrs("10.0.0.0/8", "192.168.0.0/24", "192.168.1.0/25", "2001:db8::/32")
+	testCases := []struct {
+		desc    string
+		corrupt func(s *ipset)
+		err     string
+	}{
+		{
+			desc:    "increment past 128 bits",
+			corrupt: func(s *ipset) { s.nodes[s.nodes[s.root].right].prefix = 127 },
+			err:     "bits deep",
+		},
+		{
+			desc:    "single child",
+			corrupt: func(s *ipset) { s.nodes[s.root].right = 0 },
+			err:     "only one child",
+		},
+		{
+			desc: "swapped children",
+			corrupt: func(s *ipset) {
+				n := &s.nodes[s.root]
+				n.left, n.right = n.right, n.left
+			},
+			err: "wrong side",
+		},
+		{
+			desc:    "child out of parent prefix",
+			corrupt: func(s *ipset) { s.nodes[s.nodes[s.root].right].addr = uint128.New(0, 0x6000000000000000) },
+			err:     "doesn't share prefix",
+		},
+		{
+			desc: "stale count",
+			corrupt: func(s *ipset) {
+				s.buildCounts()
+				s.counts[s.root].addrs = uint128.From64(1)
+			},
+			err: "stale counts",
+		},
+		{
+			desc: "shared node",
+			corrupt: func(s *ipset) {
+				n := &s.nodes[s.root]
+				s.free = append(s.free, n.right)
+			},
+			err: "free or referenced twice",
+		},
+		{
+			desc: "leaked node",
+			corrupt: func(s *ipset) {
+				s.Remove(parseCidrs("192.168.1.0/25")[0])
+				s.free = s.free[:len(s.free)-1]
+			},
+			err: "leaked",
+		},
+		{
+			desc: "complementing leaves",
+			corrupt: func(s *ipset) {
+				s.Remove(parseCidrs("192.168.1.0/25")[0])
+				s.Add(parseCidrs("192.168.0.0/24")[0])
+				// turn 192.168.0.0/24 into /25 and add its other half by hand
+				s.root = s.alloc(treeNode{addr: ipv4Space.Or64(0xc0a80000), prefix: 119})
+				s.nodes[s.root].left = s.alloc(treeNode{addr: ipv4Space.Or64(0xc0a80000), prefix: 1})
+				s.nodes[s.root].right = s.alloc(treeNode{addr: ipv4Space.Or64(0xc0a80100), prefix: 1})
+				s.updateCount(s.root, 0)
+			},
+			err: "not merged",
+		},
+		{
+			desc: "stale fingerprint",
+			corrupt: func(s *ipset) {
+				Fingerprint(s)
+				// last bit of 2001:db8::/32
+				right := &s.nodes[s.nodes[s.root].right]
+				right.addr = right.addr.Add(uint128.New(0, 1<<32))
+			},
+			err: "stale fingerprint",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := NewTree(cidrs...).(*ipset)
+			if err := Validate(s); err != nil {
+				t.Fatalf("valid tree reported: %v", err)
+			}
+
+			tc.corrupt(s)
+			if err := Validate(s); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("unexpected error (expected: %q, got: %v)", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateKeepsCache(t *testing.T) {
+	s := NewTree(parseCidrs("10.0.0.0/8", "192.168.0.0/24", "2001:db8::/32")...).(*ipset)
+	Fingerprint(s)
+	// stale entry is reported, not repaired
+	s.hashes[s.root].sum[0]++
+	cached := append([]nodeHash{}, s.hashes...)
+
+	for i := 0; i < 2; i++ {
+		if err := Validate(s); err == nil || !strings.Contains(err.Error(), "stale fingerprint") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(cached, s.hashes) {
+		t.Errorf("Validate changed cached fingerprints")
+	}
+}
+
+// denseModel tracks addresses of 10.0.0.0/20 covered by the set by brute force, prefixes out of it are ignored
+type denseModel [1 << 12]bool
+
+// span returns range of model indexes covered by cidr
+func (m *denseModel) span(cidr *net.IPNet) (int, int, bool) {
+	ip := cidr.IP.To4()
+	ones, bits := cidr.Mask.Size()
+	if ip == nil || bits != 32 || ones < 20 || !ip.Mask(net.CIDRMask(20, 32)).Equal(net.IPv4(10, 0, 0, 0)) {
+		return 0, 0, false
+	}
+
+	start := int(binary.BigEndian.Uint32(ip) & 0xfff)
+	return start, start + 1<<(32-ones), true
+}
+
+func (m *denseModel) set(cidr *net.IPNet, covered bool) {
+	if start, end, ok := m.span(cidr); ok {
+		for i := start; i < end; i++ {
+			m[i] = covered
+		}
+	}
+}
+
+func (m *denseModel) free(start, end int) bool {
+	for i := start; i < end; i++ {
+		if m[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check compares the model with addresses contained by s
+func (m *denseModel) check(s Set) error {
+	for i, covered := range m {
+		if ip := net.IPv4(10, 0, byte(i>>8), byte(i)); s.Contains(ip) != covered {
+			return fmt.Errorf("%s is contained: %t, model: %t", ip, !covered, covered)
+		}
+	}
+
+	return nil
+}
+
+// mutate applies random mutation to the set and to its model if there is any
+func mutate(rng *rand.Rand, s Tree, cidr *net.IPNet, m *denseModel) error {
+	if m == nil {
+		m = &denseModel{}
+	}
+
+	switch rng.Intn(6) {
+	case 0, 1:
+		s.Add(cidr)
+		m.set(cidr, true)
+	case 2, 3:
+		s.Remove(cidr)
+		m.set(cidr, false)
+	case 4:
+		ones, bits := cidr.Mask.Size()
+		prefixLen := ones + rng.Intn(bits-ones+1)
+		free, err := Gaps(s, cidr)
+		if err != nil {
+			return err
+		}
+
+		block, err := Allocate(s, cidr, prefixLen)
+		if err != nil {
+			// aligned free block exists when some of the gaps is at least as large
+			for _, gap := range free {
+				if gapLen, _ := gap.Mask.Size(); gapLen <= prefixLen {
+					return fmt.Errorf("free /%d in %s was not allocated: %v", prefixLen, gap, err)
+				}
+			}
+			return nil
+		}
+		if start, end, ok := m.span(block); ok && !m.free(start, end) {
+			return fmt.Errorf("allocated %s overlaps used space", block)
+		}
+		m.set(block, true)
+	default:
+		kept := s.Prefixes()
+		kept = kept[:len(kept)/2]
+		d, err := NewDelta(s, NewTree(kept...))
+		if err != nil {
+			return err
+		}
+		d.Apply(s)
+
+		*m = denseModel{}
+		for _, cidr := range kept {
+			m.set(cidr, true)
+		}
+	}
+
+	return nil
+}
+
+func TestValidateMutations(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewTree().(*ipset)
+	var model denseModel
+	for i := 0; i < 3000; i++ {
+		// counts are maintained by mutations once built
+		if i == 1000 {
+			s.buildCounts()
+		}
+
+		var cidr *net.IPNet
+		if i%4 == 0 {
+			cidr = randomIPv6Cidrs(rng, 1)[0]
+		} else {
+			cidr = denseIPv4Cidrs(rng, 1)[0]
+		}
+
+		if err := mutate(rng, s, cidr, &model); err != nil {
+			t.Fatalf("mutation %d with %s failed: %v", i, cidr, err)
+		}
+		if err := model.check(s); err != nil {
+			t.Fatalf("set differs from the model after %d mutations: %v", i, err)
+		}
+		if i%10 == 0 {
+			Fingerprint(s)
+		}
+		if err := Validate(s); err != nil {
+			t.Fatalf("invalid tree after %d mutations: %v", i, err)
+		}
+	}
+
+	cidrs := append(denseIPv4Cidrs(rng, 1000), ribLikeCidrs(rng, 1000)...)
+	var b Builder
+	for _, cidr := range cidrs {
+		b.Add(cidr)
+	}
+	for desc, tree := range map[string]Tree{
+		"builder":    b.Build(),
+		"parallel":   NewTreeParallel(cidrs, 4),
+		"complement": Complement(NewTree(cidrs...)),
+	} {
+		if err := Validate(tree); err != nil {
+			t.Errorf("invalid %s tree: %v", desc, err)
+		}
+	}
+}